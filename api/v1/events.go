@@ -1,25 +1,71 @@
 package v1
 
+import "time"
+
+// SchemaVersion is the version of the event schemas defined in this package,
+// except those versioned on their own below.
+const SchemaVersion = "2"
+
+// OrderReceivedVersion is the version of the OrderReceived schema. Version 3
+// added the client that placed the order.
+const OrderReceivedVersion = "3"
+
+// Event types, carried in the event-type Kafka header.
+const (
+	OrderReceivedType        = "OrderReceived"
+	OrderConfirmedType       = "OrderConfirmed"
+	OrderPickedAndPackedType = "OrderPickedAndPacked"
+	OrderErrorType           = "OrderError"
+	NotificationType         = "Notification"
+)
+
 type OrderReceived struct {
 	Header Header `json:"header"`
 	Order
+	// PlacedBy is the client that placed the order. Orders placed before
+	// clients were authenticated have none.
+	PlacedBy *Principal `json:"placedBy,omitempty"`
 }
 
+func (e OrderReceived) EventType() string     { return OrderReceivedType }
+func (e OrderReceived) SchemaVersion() string { return OrderReceivedVersion }
+func (e OrderReceived) EventID() string       { return e.Header.ID }
+func (e OrderReceived) CorrelationID() string { return e.Header.CorrelationID }
+func (e OrderReceived) EventTime() time.Time  { return e.Header.PublishedAt }
+
 type OrderPickedAndPacked struct {
 	Header Header `json:"header"`
 	Order
 }
 
+func (e OrderPickedAndPacked) EventType() string     { return OrderPickedAndPackedType }
+func (e OrderPickedAndPacked) SchemaVersion() string { return SchemaVersion }
+func (e OrderPickedAndPacked) EventID() string       { return e.Header.ID }
+func (e OrderPickedAndPacked) CorrelationID() string { return e.Header.CorrelationID }
+func (e OrderPickedAndPacked) EventTime() time.Time  { return e.Header.PublishedAt }
+
 type OrderError struct {
 	Header Header `json:"header"`
 	Event  any    `json:"event"`
 }
 
+func (e OrderError) EventType() string     { return OrderErrorType }
+func (e OrderError) SchemaVersion() string { return SchemaVersion }
+func (e OrderError) EventID() string       { return e.Header.ID }
+func (e OrderError) CorrelationID() string { return e.Header.CorrelationID }
+func (e OrderError) EventTime() time.Time  { return e.Header.PublishedAt }
+
 type OrderConfirmed struct {
 	Header Header `json:"header"`
 	Order
 }
 
+func (e OrderConfirmed) EventType() string     { return OrderConfirmedType }
+func (e OrderConfirmed) SchemaVersion() string { return SchemaVersion }
+func (e OrderConfirmed) EventID() string       { return e.Header.ID }
+func (e OrderConfirmed) CorrelationID() string { return e.Header.CorrelationID }
+func (e OrderConfirmed) EventTime() time.Time  { return e.Header.PublishedAt }
+
 type Notification struct {
 	Header    Header `json:"header"`
 	Type      string `json:"type"`
@@ -28,3 +74,9 @@ type Notification struct {
 	Subject   string `json:"subject"`
 	Body      string `json:"body"`
 }
+
+func (e Notification) EventType() string     { return NotificationType }
+func (e Notification) SchemaVersion() string { return SchemaVersion }
+func (e Notification) EventID() string       { return e.Header.ID }
+func (e Notification) CorrelationID() string { return e.Header.CorrelationID }
+func (e Notification) EventTime() time.Time  { return e.Header.PublishedAt }
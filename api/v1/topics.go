@@ -0,0 +1,99 @@
+package v1
+
+import "time"
+
+// Topics of the order flow, before the environment prefix is applied.
+const (
+	OrderReceivedTopic        = "OrderReceived"
+	OrderConfirmedTopic       = "OrderConfirmed"
+	OrderPickedAndPackedTopic = "OrderPickedAndPacked"
+	NotificationTopic         = "Notification"
+	DeadLetterQueueTopic      = "DeadLetterQueue"
+	// PurchaseLimitsTopic and PurchasesTopic carry no events. They hold the
+	// purchase limits of the catalog and the purchases counting against
+	// them, shared by the order-service instances.
+	PurchaseLimitsTopic = "PurchaseLimits"
+	PurchasesTopic      = "Purchases"
+)
+
+// AllTopics lists every topic used by the services.
+var AllTopics = []string{
+	OrderReceivedTopic,
+	OrderConfirmedTopic,
+	OrderPickedAndPackedTopic,
+	NotificationTopic,
+	DeadLetterQueueTopic,
+	PurchaseLimitsTopic,
+	PurchasesTopic,
+}
+
+// TopicRegistry resolves the topics above to the names used on the cluster,
+// so several environments can share one Kafka cluster.
+type TopicRegistry struct {
+	// Prefix is prepended to every topic followed by a dot, i.e
+	// staging.OrderReceived. Empty means no prefix.
+	Prefix string
+	// Overrides replaces the name of individual topics, before the prefix is
+	// applied.
+	Overrides map[string]string
+}
+
+// Name returns the cluster name of topic.
+func (r TopicRegistry) Name(topic string) string {
+	if o, ok := r.Overrides[topic]; ok && o != "" {
+		topic = o
+	}
+	if r.Prefix == "" {
+		return topic
+	}
+	return r.Prefix + "." + topic
+}
+
+// topicEventTypes maps the topics carrying a single event type to that type.
+var topicEventTypes = map[string]string{
+	OrderReceivedTopic:        OrderReceivedType,
+	OrderConfirmedTopic:       OrderConfirmedType,
+	OrderPickedAndPackedTopic: OrderPickedAndPackedType,
+	NotificationTopic:         NotificationType,
+}
+
+// EventType returns the event type carried by the topic named name on the
+// cluster. Messages published before the event-type header existed are
+// typed by their topic.
+func (r TopicRegistry) EventType(name string) (string, bool) {
+	for topic, eventType := range topicEventTypes {
+		if r.Name(topic) == name {
+			return eventType, true
+		}
+	}
+	return "", false
+}
+
+// TopicSpec is the layout and retention a topic is created with. The
+// replication factor depends on the cluster and is configured per
+// environment.
+type TopicSpec struct {
+	Partitions int
+	// Retention is how long messages are kept. Zero keeps them forever, for
+	// compacted topics.
+	Retention time.Duration
+	// Compact keeps only the latest message of each key.
+	Compact bool
+}
+
+// TopicSpecs holds the spec of every topic in AllTopics. Partitions are
+// keyed by order ID or customer email, so the counts bound how many consumer
+// instances can share the load.
+var TopicSpecs = map[string]TopicSpec{
+	OrderReceivedTopic:        {Partitions: 6, Retention: 7 * 24 * time.Hour},
+	OrderConfirmedTopic:       {Partitions: 6, Retention: 7 * 24 * time.Hour},
+	OrderPickedAndPackedTopic: {Partitions: 6, Retention: 7 * 24 * time.Hour},
+	NotificationTopic:         {Partitions: 3, Retention: 3 * 24 * time.Hour},
+	// Dead letters are inspected by hand, keep them around longer.
+	DeadLetterQueueTopic: {Partitions: 1, Retention: 30 * 24 * time.Hour},
+	// The latest limit of each product is kept for good. Purchases count
+	// for at most a year; a single partition orders them, see the
+	// order-service.
+	PurchaseLimitsTopic: {Partitions: 1, Compact: true},
+	PurchasesTopic:      {Partitions: 1, Retention: 366 * 24 * time.Hour, Compact: true},
+}
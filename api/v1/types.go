@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,8 +9,10 @@ import (
 )
 
 type Header struct {
-	ID          string    `json:"id"`          // GUID representing the event
-	PublishedAt time.Time `json:"publishedAt"` // Time when event was published
+	ID            string    `json:"id"`                      // GUID representing the event
+	PublishedAt   time.Time `json:"publishedAt"`             // Time when event was published
+	CorrelationID string    `json:"correlationId,omitempty"` // ID of the request that started the event chain
+	CausationID   string    `json:"causationId,omitempty"`   // ID of the event that caused this one
 }
 
 func NewHeader() Header {
@@ -19,39 +22,138 @@ func NewHeader() Header {
 	}
 }
 
+// NewCausedHeader returns a header for an event produced while handling the
+// event with header cause. The correlation ID is carried over, and events
+// published before correlation IDs existed start a new chain at cause.
+func NewCausedHeader(cause Header) Header {
+	h := NewHeader()
+	h.CausationID = cause.ID
+	h.CorrelationID = cause.CorrelationID
+	if h.CorrelationID == "" {
+		h.CorrelationID = cause.ID
+	}
+	if h.CorrelationID == "" {
+		h.CorrelationID = h.ID
+	}
+	return h
+}
+
 type Order struct {
 	OrderID  string    `json:"orderId"`
 	Products []Product `json:"products"`
 	Customer Customer  `json:"customer"`
 }
 
-func ValidateOrder(v *validator.Validator, order *Order) {
-	v.Check(len(order.Products) > 0, "products", "must contain at least 1 product")
+// DefaultCountry is the country of shipping addresses without one, as orders
+// were only shipped within the US before addresses had a country.
+const DefaultCountry = "US"
 
-	for _, p := range order.Products {
-		_, err := uuid.Parse(p.ProductID)
-		v.Check(err == nil, "productId", "productId is not valid")
-		v.Check(p.Quantity > 0, "quantity", "quantity must be more than zero")
+// NormalizeOrder puts the product IDs and customer details of an order
+// placed by a customer in their canonical form, before validation: product
+// IDs lower-cased, codes upper-cased, states abbreviated, and surrounding
+// blanks trimmed. An address without a country is in DefaultCountry, so
+// clients predating international addresses keep working.
+func NormalizeOrder(order *Order) {
+	for i := range order.Products {
+		order.Products[i].ProductID = NormalizeProductID(order.Products[i].ProductID)
 	}
 
-	v.Check(validator.Matches(order.Customer.Email, validator.EmailRX), "email", "invalid customer email address")
-	v.Check(len(order.Customer.FirstName) > 1, "firstName", "must be more than 2 characters")
-	v.Check(len(order.Customer.LastName) > 2, "lastName", "must be more than 2 characters")
+	c := &order.Customer
+	c.FirstName = strings.TrimSpace(c.FirstName)
+	c.LastName = strings.TrimSpace(c.LastName)
+	c.Email = strings.TrimSpace(c.Email)
+	c.Phone = validator.NormalizePhone(c.Phone)
+
+	a := &c.ShippingAddress
+	a.Country = validator.NormalizeCountry(a.Country)
+	if a.Country == "" {
+		a.Country = DefaultCountry
+	}
+	a.Street = strings.TrimSpace(a.Street)
+	a.Street2 = strings.TrimSpace(a.Street2)
+	a.City = strings.TrimSpace(a.City)
+	a.State = validator.NormalizeState(a.Country, a.State)
+	a.PostalCode = validator.NormalizePostalCode(a.Country, a.PostalCode)
+}
+
+// NormalizeProductID returns a product ID, a UUID, in lower case, so each
+// product has a single ID whatever the case it is spelled in.
+func NormalizeProductID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// ValidateOrder checks an order placed by a customer, normalized by
+// NormalizeOrder. Fields are reported by their JSON paths, i.e
+// products[1].quantity.
+func ValidateOrder(v *validator.Validator, order *Order) {
+	v.Check(len(order.Products) > 0, "products", validator.CodeRequired, "must contain at least 1 product")
+	for i, p := range order.Products {
+		product := v.Element("products", i)
+		product.UUID("productId", p.ProductID)
+		product.Min("quantity", p.Quantity, 1)
+	}
 
-	v.Check(len(order.Customer.ShippingAddress.City) > 0, "city", "is required")
-	v.Check(len(order.Customer.ShippingAddress.PostalCode) > 0, "postalCode", "is required")
-	v.Check(len(order.Customer.ShippingAddress.State) > 0, "state", "is required")
-	v.Check(len(order.Customer.ShippingAddress.Street) > 0, "street", "is required")
+	customer := v.Nested("customer")
+	if customer.Required("firstName", order.Customer.FirstName) &&
+		customer.MinLength("firstName", order.Customer.FirstName, 2) {
+		customer.MaxLength("firstName", order.Customer.FirstName, 100)
+	}
+	if customer.Required("lastName", order.Customer.LastName) &&
+		customer.MinLength("lastName", order.Customer.LastName, 3) {
+		customer.MaxLength("lastName", order.Customer.LastName, 100)
+	}
+	if customer.Required("emailAddress", order.Customer.Email) {
+		customer.Email("emailAddress", order.Customer.Email)
+	}
+	if order.Customer.Phone != "" {
+		customer.Phone("phone", order.Customer.Phone)
+	}
 
+	address := customer.Nested("shippingAddress")
+	a := order.Customer.ShippingAddress
+	if address.Required("street", a.Street) {
+		address.MaxLength("street", a.Street, 200)
+	}
+	address.MaxLength("street2", a.Street2, 200)
+	if address.Required("city", a.City) {
+		address.MaxLength("city", a.City, 100)
+	}
+	// The rules of the other fields depend on the country.
+	if address.Country("country", a.Country) {
+		address.State("state", a.Country, a.State)
+		address.PostalCode("postalCode", a.Country, a.PostalCode)
+	}
 }
 
-func (o Order) ToOrderReceivedEvent() OrderReceived {
+// EventSubject identifies the order the event is about.
+func (o Order) EventSubject() string { return o.OrderID }
+
+// ToOrderReceivedEvent starts a new event chain for the order, correlated
+// with the request that placed it. placedBy is nil for orders placed without
+// authentication.
+func (o Order) ToOrderReceivedEvent(correlationID string, placedBy *Principal) OrderReceived {
+	h := NewHeader()
+	h.CorrelationID = correlationID
+	if h.CorrelationID == "" {
+		h.CorrelationID = h.ID
+	}
 	return OrderReceived{
-		Header: NewHeader(),
-		Order:  o,
+		Header:   h,
+		Order:    o,
+		PlacedBy: placedBy,
 	}
 }
 
+// Principal is an authenticated client of the API.
+type Principal struct {
+	// Subject identifies the client, the name of an API key or the user of
+	// a bearer token.
+	Subject string `json:"subject"`
+	// Method is how the client authenticated: api_key, jwt or none when
+	// authentication is disabled.
+	Method string `json:"method"`
+}
+
 type Product struct {
 	ProductID string `json:"productId"`
 	// Quantity of the product. Can represent the stoage of the amount ordered.
@@ -59,13 +161,23 @@ type Product struct {
 }
 
 type Customer struct {
-	FirstName       string `json:"firstName"`
-	LastName        string `json:"lastName"`
-	Email           string `json:"emailAddress"`
-	ShippingAddress struct {
-		Street     string `json:"street"`
-		City       string `json:"city"`
-		State      string `json:"state"`
-		PostalCode string `json:"postalCode"`
-	} `json:"shippingAddress"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"emailAddress"`
+	// Phone is an international number in E.164 format, i.e +15551234567.
+	Phone           string  `json:"phone,omitempty"`
+	ShippingAddress Address `json:"shippingAddress"`
+}
+
+type Address struct {
+	Street  string `json:"street"`
+	Street2 string `json:"street2,omitempty"` // Apartment, suite, unit, etc.
+	City    string `json:"city"`
+	// State is the code of the state or province, required by the countries
+	// that use them in addresses, i.e US and CA.
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postalCode"`
+	// Country is an ISO 3166-1 alpha-2 code. Orders placed without one ship
+	// to DefaultCountry.
+	Country string `json:"country"`
 }
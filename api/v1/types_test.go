@@ -0,0 +1,129 @@
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snirkop89/ppe-ecommerce/core/validator"
+)
+
+func testOrder() Order {
+	return Order{
+		OrderID:  "6e3c0a86-7c1a-4a8e-9c53-2f7a1d5b9e10",
+		Products: []Product{{ProductID: "6bc91dc9-b1f1-48c8-9dea-e600470dfb95", Quantity: 2}},
+		Customer: Customer{
+			FirstName: "Bruce",
+			LastName:  "Wayne",
+			Email:     "bruce@wayne.com",
+			ShippingAddress: Address{
+				Street:     "1007 Mountain Dr.",
+				City:       "Gotham",
+				State:      "NJ",
+				PostalCode: "07001",
+				Country:    "US",
+			},
+		},
+	}
+}
+
+// fieldErrors returns the field and code of every error, as field=code.
+func fieldErrors(v *validator.Validator) []string {
+	var errs []string
+	for _, e := range v.Errors() {
+		errs = append(errs, e.Field+"="+e.Code)
+	}
+	return errs
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		edit   func(o *Order)
+		errors []string
+	}{
+		{"valid", func(o *Order) {}, nil},
+		{"no products", func(o *Order) { o.Products = nil }, []string{"products=required"}},
+		{"invalid product", func(o *Order) {
+			o.Products = append(o.Products, Product{ProductID: "42", Quantity: 0})
+		}, []string{"products[1].productId=invalid_uuid", "products[1].quantity=out_of_range"}},
+		{"shortest names", func(o *Order) { o.Customer.FirstName, o.Customer.LastName = "Al", "Lee" }, nil},
+		{"first name too short", func(o *Order) { o.Customer.FirstName = "A" }, []string{"customer.firstName=too_short"}},
+		{"last name too short", func(o *Order) { o.Customer.LastName = "Li" }, []string{"customer.lastName=too_short"}},
+		{"no names", func(o *Order) { o.Customer.FirstName, o.Customer.LastName = "", "" },
+			[]string{"customer.firstName=required", "customer.lastName=required"}},
+		{"invalid email", func(o *Order) { o.Customer.Email = "bruce" }, []string{"customer.emailAddress=invalid_email"}},
+		{"invalid phone", func(o *Order) { o.Customer.Phone = "555" }, []string{"customer.phone=invalid_phone"}},
+		{"no street and city", func(o *Order) { o.Customer.ShippingAddress.Street, o.Customer.ShippingAddress.City = "", "" },
+			[]string{"customer.shippingAddress.street=required", "customer.shippingAddress.city=required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := testOrder()
+			tt.edit(&order)
+			v := validator.New()
+			ValidateOrder(v, &order)
+			if got := fieldErrors(v); !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("got errors %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
+
+func TestOrderAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address Address
+		// want is the address once normalized.
+		want   Address
+		errors []string
+	}{
+		{
+			name:    "no country ships to the US",
+			address: Address{Street: "1007 Mountain Dr.", City: "Gotham", State: "new jersey", PostalCode: "07001"},
+			want:    Address{Street: "1007 Mountain Dr.", City: "Gotham", State: "NJ", PostalCode: "07001", Country: "US"},
+		},
+		{
+			name:    "no country, not a US address",
+			address: Address{Street: "10 Downing St", City: "London", PostalCode: "sw1a2aa"},
+			want:    Address{Street: "10 Downing St", City: "London", PostalCode: "SW1A2AA", Country: "US"},
+			errors:  []string{"customer.shippingAddress.state=required", "customer.shippingAddress.postalCode=invalid_postal_code"},
+		},
+		{
+			name:    "GB",
+			address: Address{Street: "10 Downing St", City: "London", PostalCode: "sw1a2aa", Country: " gb"},
+			want:    Address{Street: "10 Downing St", City: "London", PostalCode: "SW1A 2AA", Country: "GB"},
+		},
+		{
+			name:    "CA",
+			address: Address{Street: "24 Sussex Dr", City: "Ottawa", State: "Ontario", PostalCode: "k1m1m4", Country: "CA"},
+			want:    Address{Street: "24 Sussex Dr", City: "Ottawa", State: "ON", PostalCode: "K1M 1M4", Country: "CA"},
+		},
+		{
+			name:    "CA without a province",
+			address: Address{Street: "24 Sussex Dr", City: "Ottawa", PostalCode: "K1M 1M4", Country: "CA"},
+			want:    Address{Street: "24 Sussex Dr", City: "Ottawa", PostalCode: "K1M 1M4", Country: "CA"},
+			errors:  []string{"customer.shippingAddress.state=required"},
+		},
+		{
+			name:    "unknown country",
+			address: Address{Street: "1 Main St", City: "Nowhere", PostalCode: "1", Country: "XX"},
+			want:    Address{Street: "1 Main St", City: "Nowhere", PostalCode: "1", Country: "XX"},
+			errors:  []string{"customer.shippingAddress.country=invalid_country"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := testOrder()
+			order.Customer.ShippingAddress = tt.address
+			NormalizeOrder(&order)
+			if order.Customer.ShippingAddress != tt.want {
+				t.Errorf("got address %+v, want %+v", order.Customer.ShippingAddress, tt.want)
+			}
+			v := validator.New()
+			ValidateOrder(v, &order)
+			if got := fieldErrors(v); !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("got errors %v, want %v", got, tt.errors)
+			}
+		})
+	}
+}
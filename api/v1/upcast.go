@@ -0,0 +1,137 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// UpcastFunc migrates the decoded JSON of an event one schema version up.
+// It modifies doc in place.
+type UpcastFunc func(doc map[string]any) error
+
+type upcastStep struct {
+	eventType string
+	from      int
+}
+
+// Upcasters holds the migrations of events from older schema versions to
+// their current one, so consumers can read messages published before an
+// event changed.
+//
+// Events are versioned on their own. A migration is only registered for the
+// versions an event type changed in. To change an event: bump its version,
+// change the Go type and the schema document, and register an upcaster from
+// the previous version that rewrites the old JSON into the new shape, unless
+// the old JSON already fits.
+type Upcasters struct {
+	current  int
+	versions map[string]int
+	steps    map[upcastStep]UpcastFunc
+}
+
+// NewUpcasters returns an empty set of migrations up to version current for
+// every event type without a version of its own.
+func NewUpcasters(current int) *Upcasters {
+	return &Upcasters{
+		current:  current,
+		versions: make(map[string]int),
+		steps:    make(map[upcastStep]UpcastFunc),
+	}
+}
+
+// DefaultUpcasters migrates every event version published so far to the
+// version of its type.
+var DefaultUpcasters = NewUpcasters(mustParseVersion(SchemaVersion))
+
+func init() {
+	// Version 2 added the country of the shipping address, see
+	// DefaultCountry.
+	for _, eventType := range []string{OrderReceivedType, OrderConfirmedType, OrderPickedAndPackedType} {
+		DefaultUpcasters.Register(eventType, 1, addShippingCountry)
+	}
+	// Version 3 of OrderReceived added the optional client that placed the
+	// order, version 2 events are valid as they are.
+	DefaultUpcasters.SetVersion(OrderReceivedType, mustParseVersion(OrderReceivedVersion))
+}
+
+func addShippingCountry(doc map[string]any) error {
+	customer, ok := doc["customer"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("customer is %T, want an object", doc["customer"])
+	}
+	address, ok := customer["shippingAddress"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("customer.shippingAddress is %T, want an object", customer["shippingAddress"])
+	}
+	if _, ok := address["country"]; !ok {
+		address["country"] = DefaultCountry
+	}
+	return nil
+}
+
+// SetVersion sets the current version of eventType.
+func (u *Upcasters) SetVersion(eventType string, version int) {
+	u.versions[eventType] = version
+}
+
+// Register adds fn as the migration of eventType from version from to
+// from+1.
+func (u *Upcasters) Register(eventType string, from int, fn UpcastFunc) {
+	u.steps[upcastStep{eventType: eventType, from: from}] = fn
+}
+
+// ParseVersion parses a schema version. Events published before versions
+// were recorded are version 1.
+func ParseVersion(version string) (int, error) {
+	if version == "" {
+		return 1, nil
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid schema version %q", version)
+	}
+	return v, nil
+}
+
+func mustParseVersion(version string) int {
+	v, err := ParseVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Current returns the version eventType events are migrated to.
+func (u *Upcasters) Current(eventType string) int {
+	if v, ok := u.versions[eventType]; ok {
+		return v
+	}
+	return u.current
+}
+
+// Upcast migrates data, an eventType event at version, to the current
+// version. Data already at the current version is returned as is.
+// Versions newer than the current one can't be migrated and are returned
+// unchanged; whether to accept them is up to the caller.
+func (u *Upcasters) Upcast(eventType string, version int, data []byte) ([]byte, error) {
+	current := u.Current(eventType)
+	if version >= current {
+		return data, nil
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for v := version; v < current; v++ {
+		fn, ok := u.steps[upcastStep{eventType: eventType, from: v}]
+		if !ok {
+			continue
+		}
+		if err := fn(doc); err != nil {
+			return nil, fmt.Errorf("upcast %s from version %d: %w", eventType, v, err)
+		}
+	}
+	return json.Marshal(doc)
+}
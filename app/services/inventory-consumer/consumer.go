@@ -1,22 +1,31 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/dgraph-io/badger/v4"
 	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
-	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	"github.com/snirkop89/ppe-ecommerce/core/consumer"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
 	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"github.com/snirkop89/ppe-ecommerce/schemas"
 )
 
 func (app *application) consumeOrders(ctx context.Context) error {
-	app.log.Info("Started consuming messages", "topic", "OrderReceived")
+	topic := app.topics.Name(v1.OrderReceivedTopic)
+	app.log.Info("Started consuming messages", "topic", topic)
 
-	err := app.consumer.Subscribe("OrderReceived", nil)
+	router := consumer.NewRouter()
+	router.Handle(v1.OrderReceivedType, app.handleOrderReceived)
+	router.DeadLetter(app.deadLetter)
+	router.TopicTypes(app.topics.EventType)
+
+	err := app.consumer.Subscribe(topic, app.tracker.RebalanceCallback)
 	if err != nil {
 		return err
 	}
@@ -24,7 +33,8 @@ func (app *application) consumeOrders(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			app.log.Info("Stopped consuming messages")
+			return nil
 		default:
 			msg, err := app.consumer.ReadMessage(10 * time.Second)
 			if err != nil {
@@ -33,36 +43,73 @@ func (app *application) consumeOrders(ctx context.Context) error {
 				}
 				continue
 			}
+			app.tracker.Observe(msg)
 
-			// Parse msg
-			var orderReceived v1.OrderReceived
-			err = httpio.Decode(bytes.NewReader(msg.Value), &orderReceived)
+			// Finish handling the message even if shutdown starts meanwhile,
+			// so it is not left half processed, within the shutdown timeout.
+			hctx, hcancel := context.WithTimeout(context.WithoutCancel(ctx), app.config.ShutdownTimeout)
+			err = router.Dispatch(hctx, msg)
+			hcancel()
 			if err != nil {
-				app.handleError(orderReceived, err)
-				continue
+				app.log.Error("handling message", "event_type", consumer.EventType(msg), "error", err)
+				// A fenced or failed transactional producer publishes
+				// nothing anymore, the consumer stops rather than skip
+				// messages.
+				if publisher.IsFatal(err) {
+					return err
+				}
 			}
+		}
+	}
+}
 
-			app.log.Info("Order received", "order", orderReceived)
-			handled, err := app.alreadyHandled(orderReceived.Header.ID)
-			if err != nil {
-				app.handleError(orderReceived, err)
-				continue
-			}
-			if handled {
-				app.log.Info("Event already handled", "event_id", orderReceived.Header.ID)
-				continue
-			}
-			if err := app.saveMessage(orderReceived.Header.ID); err != nil {
-				app.log.Error("failed saving message", "error", err.Error())
-			}
+func (app *application) handleOrderReceived(ctx context.Context, msg *kafka.Message) error {
+	// Parse msg
+	var orderReceived v1.OrderReceived
+	err := app.decoder.DecodeMessage(v1.OrderReceivedType, msg, &orderReceived)
+	if err != nil {
+		return app.handleError(ctx, msg, orderReceived, err)
+	}
 
-			// Publish OrderConfirmed
-			app.publishOrderConfirmed(ctx, orderReceived.Order)
+	app.log.InfoContext(ctx, "Order received", "order", orderReceived)
+	handled, err := app.alreadyHandled(ctx, orderReceived.Header.ID)
+	if err != nil {
+		return app.handleError(ctx, msg, orderReceived, err)
+	}
+	if handled {
+		metrics.DuplicateSkipped(*msg.TopicPartition.Topic)
+		app.log.InfoContext(ctx, "Event already handled", "event_id", orderReceived.Header.ID)
+		return app.commitOffset(ctx, msg)
+	}
+
+	if app.txProducer != nil {
+		err := app.txProducer.PublishWithOffset(ctx, app.consumer, msg,
+			publisher.Event{Topic: v1.OrderConfirmedTopic, Key: orderReceived.OrderID, Data: newOrderConfirmed(orderReceived.Header, orderReceived.Order)},
+		)
+		if err != nil {
+			return app.publishFailed(ctx, msg, orderReceived, fmt.Errorf("transaction failed: %w", err))
+		}
+		if err := app.saveMessage(ctx, orderReceived.Header.ID); err != nil {
+			app.log.ErrorContext(ctx, "failed saving message", "error", err.Error())
 		}
+		return nil
+	}
+
+	// The event is marked handled once what it publishes is delivered,
+	// otherwise it is consumed again.
+	if err := app.publishOrderConfirmed(ctx, orderReceived.Header, orderReceived.Order); err != nil {
+		return app.publishFailed(ctx, msg, orderReceived, err)
 	}
+	if err := app.saveMessage(ctx, orderReceived.Header.ID); err != nil {
+		app.log.ErrorContext(ctx, "failed saving message", "error", err.Error())
+	}
+	return nil
 }
 
-func (app *application) alreadyHandled(eventID string) (bool, error) {
+func (app *application) alreadyHandled(ctx context.Context, eventID string) (bool, error) {
+	_, span := tracing.Start(ctx, "badger.alreadyHandled")
+	defer span.End()
+
 	found := false
 	err := app.db.View(func(txn *badger.Txn) error {
 		_, err := txn.Get([]byte(eventID))
@@ -76,46 +123,119 @@ func (app *application) alreadyHandled(eventID string) (bool, error) {
 		return nil
 	})
 	if err != nil {
+		tracing.RecordError(span, err)
 		return false, err
 	}
 	return found, nil
 }
 
-func (app *application) saveMessage(eventID string) error {
-	return app.db.Update(func(txn *badger.Txn) error {
+func (app *application) saveMessage(ctx context.Context, eventID string) error {
+	_, span := tracing.Start(ctx, "badger.saveMessage")
+	defer span.End()
+
+	err := app.db.Update(func(txn *badger.Txn) error {
 		e := badger.NewEntry([]byte(eventID), []byte("1")).WithTTL(7 * 24 * time.Hour)
 		return txn.SetEntry(e)
 	})
+	tracing.RecordError(span, err)
+	return err
 }
 
-func (app *application) handleError(order v1.OrderReceived, err error) {
-	app.log.Error(err.Error())
-	app.publishError(order)
+// deadLetter forwards a message no handler can take to the dead letter
+// queue as consumed. In transactional mode its offset is committed with it.
+func (app *application) deadLetter(ctx context.Context, msg *kafka.Message) error {
+	metrics.DeadLettered(*msg.TopicPartition.Topic)
+	if app.txProducer != nil {
+		return app.publishWithOffset(ctx, msg,
+			publisher.Event{Topic: v1.DeadLetterQueueTopic, Forward: msg},
+		)
+	}
+	return app.producer.Forward(ctx, v1.DeadLetterQueueTopic, msg)
 }
 
-func (app *application) publishError(order v1.OrderReceived) error {
-	var topic string = "DeadLetterQueue"
+// handleError reports a failed message to the dead letter queue, keyed like
+// the original message so its partition ordering is kept.
+func (app *application) handleError(ctx context.Context, msg *kafka.Message, order v1.OrderReceived, err error) error {
+	app.log.ErrorContext(ctx, err.Error())
+	metrics.DeadLettered(*msg.TopicPartition.Topic)
+	if err := app.publishError(ctx, msg, order); err != nil {
+		return fmt.Errorf("publishing to dead letter queue: %w", err)
+	}
+	return nil
+}
+
+// publishError publishes order to the dead letter queue. In transactional
+// mode the offset of msg is committed with it, as nothing else would.
+func (app *application) publishError(ctx context.Context, msg *kafka.Message, order v1.OrderReceived) error {
+	topic := v1.DeadLetterQueueTopic
 	errorEvent := v1.OrderError{
-		Header: v1.NewHeader(),
+		Header: v1.NewCausedHeader(order.Header),
 		Event:  order,
 	}
-	if err := publisher.PublishEvent("localhost", topic, errorEvent); err != nil {
+	if app.txProducer != nil {
+		return app.publishWithOffset(ctx, msg,
+			publisher.Event{Topic: topic, Key: string(msg.Key), Data: errorEvent},
+		)
+	}
+	if err := app.producer.PublishEvent(ctx, topic, string(msg.Key), errorEvent); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (app *application) publishOrderConfirmed(ctx context.Context, confirmed v1.Order) error {
-	if ctx.Err() != nil {
-		return ctx.Err()
+// commitOffset commits the offset of msg when it was handled without
+// publishing anything. Outside of transactional mode offsets are committed
+// automatically.
+func (app *application) commitOffset(ctx context.Context, msg *kafka.Message) error {
+	if app.txProducer == nil {
+		return nil
 	}
-	topic := "OrderConfirmed"
-	oc := v1.OrderConfirmed{
-		Header: v1.NewHeader(),
-		Order:  confirmed,
+	if err := app.publishWithOffset(ctx, msg); err != nil {
+		return fmt.Errorf("commit offset: %w", err)
+	}
+	return nil
+}
+
+// publishWithOffset publishes events with the offset of msg in a single
+// transaction. On failure the consumer is rewound to msg, or the offset
+// committed by the next transaction would skip it.
+func (app *application) publishWithOffset(ctx context.Context, msg *kafka.Message, events ...publisher.Event) error {
+	err := app.txProducer.PublishWithOffset(ctx, app.consumer, msg, events...)
+	if err != nil {
+		app.rewind(ctx, msg)
+	}
+	return err
+}
+
+// publishFailed dead-letters msg when an event it publishes violates its
+// schema, which fails every retry, or rewinds the consumer to retry it.
+func (app *application) publishFailed(ctx context.Context, msg *kafka.Message, order v1.OrderReceived, err error) error {
+	var invalid *schemas.ValidationError
+	if errors.As(err, &invalid) {
+		return app.handleError(ctx, msg, order, err)
+	}
+	app.rewind(ctx, msg)
+	return err
+}
+
+// rewind moves the consumer back to msg so it is delivered again.
+func (app *application) rewind(ctx context.Context, msg *kafka.Message) {
+	if err := publisher.Rewind(app.consumer, msg); err != nil {
+		app.log.ErrorContext(ctx, "rewinding consumer", "error", err)
 	}
-	if err := publisher.PublishEvent("localhost", topic, oc); err != nil {
+}
+
+func (app *application) publishOrderConfirmed(ctx context.Context, cause v1.Header, confirmed v1.Order) error {
+	topic := v1.OrderConfirmedTopic
+	if err := app.producer.PublishEventSync(ctx, topic, confirmed.OrderID, newOrderConfirmed(cause, confirmed)); err != nil {
 		return err
 	}
 	return nil
 }
+
+func newOrderConfirmed(cause v1.Header, confirmed v1.Order) v1.OrderConfirmed {
+	return v1.OrderConfirmed{
+		Header: v1.NewCausedHeader(cause),
+		Order:  confirmed,
+	}
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"errors"
-	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,59 +15,163 @@ import (
 	"github.com/dgraph-io/badger/v4"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/config"
+	"github.com/snirkop89/ppe-ecommerce/core/consumer"
+	"github.com/snirkop89/ppe-ecommerce/core/health"
 	"github.com/snirkop89/ppe-ecommerce/core/logger"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/topic"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"github.com/snirkop89/ppe-ecommerce/schemas"
 	"golang.org/x/sync/errgroup"
 )
 
-type config struct {
-	Addr   string
-	DBPath string
-	Kafka  struct {
-		server string
-	}
-}
+// serviceName identifies the service in logs and message headers.
+const serviceName = "inventory-consumer"
 
 type application struct {
-	config   config
+	config   config.Config
 	log      *slog.Logger
 	consumer *kafka.Consumer
 	db       *badger.DB
+	producer *publisher.Producer
+	tracker  *consumer.Tracker
+	// topics resolves topic names for the configured environment.
+	topics  v1.TopicRegistry
+	decoder *schemas.Decoder
+	// txProducer is set when running in transactional mode.
+	txProducer *publisher.TransactionalProducer
 }
 
 func main() {
-	var cfg config
-	flag.StringVar(&cfg.Addr, "addr", ":8081", "address to listen on, i.e 127.0.0.1:8000")
-	flag.StringVar(&cfg.DBPath, "db-path", "/tmp/inventory-consumer", "directory to create database")
-	flag.StringVar(&cfg.Kafka.server, "kafka-server", "localhost", "kafka server address")
-	flag.Parse()
+	cfg, err := config.Load(serviceName, config.Config{
+		Consumer: true,
+		Addr:     ":8081",
+		DBPath:   "/tmp/inventory-consumer",
+		Kafka: config.Kafka{
+			GroupID: "inventory",
+		},
+	}, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	log := logger.NewLogger("inventory-consumer")
+	log := logger.NewLogger(serviceName)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, cfg.Tracing)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		tCtx, tcancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer tcancel()
+		if err := shutdownTracing(tCtx); err != nil {
+			log.Error("shutting down tracing", "error", err)
+		}
+	}()
 
 	// Open th embedded database. Used for saving handles kafka messages,
 	// to avoid duplication.
-	db, err := badger.Open(badger.DefaultOptions("/tmp/inventory-consumer"))
+	db, err := badger.Open(badger.DefaultOptions(cfg.DBPath))
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
 	defer db.Close()
+	metrics.RegisterBadgerSize(db)
 
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": cfg.Kafka.server,
-		"group.id":          "inventory",
-		"auto.offset.reset": "earliest",
-	})
+	c, err := kafka.NewConsumer(cfg.Kafka.ConsumerConfig())
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
 	defer c.Close()
+
+	// Shared producer for every event published by the service.
+	producerConfig, err := cfg.Kafka.ProducerConfig()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	p, err := publisher.New(producerConfig, serviceName, log)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer p.Close()
+	// Events are validated against their schemas when published and consumed.
+	registry, err := schemas.Load()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	// Events are published in the configured codec and consumed in any.
+	codecs, err := schemas.Codecs(cfg.SchemaRegistry)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	encoder, err := codecs.ByFormat(cfg.Kafka.Codec)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	topics := cfg.Kafka.TopicRegistry()
+	p.Topics = topics
+	p.Schemas = registry
+	p.Codec = encoder
+	p.Envelope = cfg.Kafka.Envelope
+
+	// Create the topics the service needs, or report how existing ones
+	// differ from their specs.
+	admin, err := kafka.NewAdminClientFromProducer(p.Client)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer admin.Close()
+	provisioner := topic.NewProvisioner(admin, log, cfg.Kafka.TopicSpecs(v1.OrderReceivedTopic, v1.OrderConfirmedTopic, v1.DeadLetterQueueTopic)...)
+	provisionCtx, provisionCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := provisioner.Ensure(provisionCtx); err != nil {
+		log.Error("Topics don't match their specs", "error", err)
+	}
+	provisionCancel()
+
 	app := &application{
 		config:   cfg,
 		log:      log,
 		consumer: c,
 		db:       db,
+		producer: p,
+		tracker:  consumer.NewTracker(c),
+		topics:   topics,
+		decoder: &schemas.Decoder{
+			Registry:  registry,
+			Upcasters: v1.DefaultUpcasters,
+			Policy:    cfg.DecodePolicy,
+			Codecs:    codecs,
+		},
+	}
+
+	if cfg.Kafka.Transactional {
+		initCtx, initCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		tp, err := publisher.NewTransactional(initCtx, cfg.Kafka.ClientConfig(), cfg.Kafka.TransactionalID, serviceName)
+		initCancel()
+		if err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		defer tp.Close()
+		tp.Topics = topics
+		tp.Schemas = registry
+		tp.Codec = encoder
+		tp.Envelope = cfg.Kafka.Envelope
+		app.txProducer = tp
+		log.Info("Transactional mode enabled", "transactional_id", cfg.Kafka.TransactionalID)
 	}
 
 	// Prepare a context to catch cancelation signals.
@@ -78,15 +182,34 @@ func main() {
 	g.Go(func() error {
 		return app.consumeOrders(ctx)
 	})
+	g.Go(func() error {
+		return app.tracker.Run(ctx, 15*time.Second)
+	})
+	g.Go(func() error {
+		return provisioner.Run(ctx, time.Minute)
+	})
+
+	checker := health.New(3 * time.Second)
+	checker.Add("kafka", health.KafkaBroker(c))
+	checker.Add("consumer", health.ConsumerJoined(app.tracker))
+	checker.Add("badger", health.BadgerWritable(db))
+	checker.Add("outbox", health.OutboxBacklog(p.Client, cfg.MaxBacklog))
+	checker.Add("topics", provisioner.Check)
 
 	// Setup routes
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(tracing.Middleware)
 	r.Use(logger.LoggingMiddleware(log))
 
+	r.Handle("/metrics", metrics.Handler())
+
 	r.Route("/v1", func(r chi.Router) {
-		r.Get("/healthcheck", httpio.HealthCheckHandler)
+		r.Get("/healthcheck", health.LivenessHandler)
+		r.Get("/health/live", health.LivenessHandler)
+		r.Get("/health/ready", checker.ReadinessHandler)
+		r.Get("/admin/consumer", app.tracker.Handler)
 	})
 
 	srv := &http.Server{
@@ -109,7 +232,7 @@ func main() {
 		<-ctx.Done()
 		log.Info("Received termination signal. Shutting down server")
 
-		tCtx, tcancel := context.WithTimeout(context.Background(), 10*time.Second)
+		tCtx, tcancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 		defer tcancel()
 
 		err = srv.Shutdown(tCtx)
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"time"
@@ -9,14 +8,21 @@ import (
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/dgraph-io/badger/v4"
 	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
-	"github.com/snirkop89/ppe-ecommerce/core/httpio"
-	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/consumer"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
 )
 
 func (app *application) consumeOrders(ctx context.Context) error {
-	app.log.Info("Started consuming messages", "topic", "Notification")
+	topic := app.topics.Name(v1.NotificationTopic)
+	app.log.Info("Started consuming messages", "topic", topic)
 
-	err := app.consumer.Subscribe("Notification", nil)
+	router := consumer.NewRouter()
+	router.Handle(v1.NotificationType, app.handleNotification)
+	router.DeadLetter(app.deadLetter)
+	router.TopicTypes(app.topics.EventType)
+
+	err := app.consumer.Subscribe(topic, app.tracker.RebalanceCallback)
 	if err != nil {
 		return err
 	}
@@ -24,7 +30,8 @@ func (app *application) consumeOrders(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			app.log.Info("Stopped consuming messages")
+			return nil
 		default:
 			msg, err := app.consumer.ReadMessage(10 * time.Second)
 			if err != nil {
@@ -33,35 +40,52 @@ func (app *application) consumeOrders(ctx context.Context) error {
 				}
 				continue
 			}
+			app.tracker.Observe(msg)
 
-			// Parse msg
-			var notification v1.Notification
-			err = httpio.Decode(bytes.NewReader(msg.Value), &notification)
+			// Finish handling the message even if shutdown starts meanwhile,
+			// so it is not left half processed, within the shutdown timeout.
+			hctx, hcancel := context.WithTimeout(context.WithoutCancel(ctx), app.config.ShutdownTimeout)
+			err = router.Dispatch(hctx, msg)
+			hcancel()
 			if err != nil {
-				app.handleError(notification, err)
-				continue
+				app.log.Error("handling message", "event_type", consumer.EventType(msg), "error", err)
 			}
+		}
+	}
+}
 
-			app.log.Info("notification received", "event", notification)
-			handled, err := app.alreadyHandled(notification.Header.ID)
-			if err != nil {
-				app.handleError(notification, err)
-				continue
-			}
-			if handled {
-				app.log.Info("Event already handled", "event_id", notification.Header.ID)
-				continue
-			}
-			if err := app.saveMessage(notification.Header.ID); err != nil {
-				app.log.Error("failed saving message", "error", err.Error())
-			}
+func (app *application) handleNotification(ctx context.Context, msg *kafka.Message) error {
+	// Parse msg
+	var notification v1.Notification
+	err := app.decoder.DecodeMessage(v1.NotificationType, msg, &notification)
+	if err != nil {
+		app.handleError(ctx, msg, notification, err)
+		return nil
+	}
 
-			app.sendNotification(ctx, notification)
-		}
+	app.log.InfoContext(ctx, "notification received", "event", notification)
+	handled, err := app.alreadyHandled(ctx, notification.Header.ID)
+	if err != nil {
+		app.handleError(ctx, msg, notification, err)
+		return nil
+	}
+	if handled {
+		metrics.DuplicateSkipped(*msg.TopicPartition.Topic)
+		app.log.InfoContext(ctx, "Event already handled", "event_id", notification.Header.ID)
+		return nil
+	}
+	if err := app.saveMessage(ctx, notification.Header.ID); err != nil {
+		app.log.ErrorContext(ctx, "failed saving message", "error", err.Error())
 	}
+
+	app.sendNotification(ctx, notification)
+	return nil
 }
 
-func (app *application) alreadyHandled(eventID string) (bool, error) {
+func (app *application) alreadyHandled(ctx context.Context, eventID string) (bool, error) {
+	_, span := tracing.Start(ctx, "badger.alreadyHandled")
+	defer span.End()
+
 	found := false
 	err := app.db.View(func(txn *badger.Txn) error {
 		_, err := txn.Get([]byte(eventID))
@@ -75,37 +99,53 @@ func (app *application) alreadyHandled(eventID string) (bool, error) {
 		return nil
 	})
 	if err != nil {
+		tracing.RecordError(span, err)
 		return false, err
 	}
 	return found, nil
 }
 
-func (app *application) saveMessage(eventID string) error {
-	return app.db.Update(func(txn *badger.Txn) error {
+func (app *application) saveMessage(ctx context.Context, eventID string) error {
+	_, span := tracing.Start(ctx, "badger.saveMessage")
+	defer span.End()
+
+	err := app.db.Update(func(txn *badger.Txn) error {
 		e := badger.NewEntry([]byte(eventID), []byte("1")).WithTTL(7 * 24 * time.Hour)
 		return txn.SetEntry(e)
 	})
+	tracing.RecordError(span, err)
+	return err
+}
+
+// deadLetter forwards a message no handler can take to the dead letter
+// queue as consumed.
+func (app *application) deadLetter(ctx context.Context, msg *kafka.Message) error {
+	metrics.DeadLettered(*msg.TopicPartition.Topic)
+	return app.producer.Forward(ctx, v1.DeadLetterQueueTopic, msg)
 }
 
-func (app *application) handleError(order v1.Notification, err error) {
-	app.log.Error(err.Error())
-	app.publishError(order)
+// handleError reports a failed message to the dead letter queue, keyed like
+// the original message so its partition ordering is kept.
+func (app *application) handleError(ctx context.Context, msg *kafka.Message, order v1.Notification, err error) {
+	app.log.ErrorContext(ctx, err.Error())
+	metrics.DeadLettered(*msg.TopicPartition.Topic)
+	app.publishError(ctx, string(msg.Key), order)
 }
 
-func (app *application) publishError(notification v1.Notification) error {
-	var topic string = "DeadLetterQueue"
+func (app *application) publishError(ctx context.Context, key string, notification v1.Notification) error {
+	topic := v1.DeadLetterQueueTopic
 	errorEvent := v1.OrderError{
-		Header: v1.NewHeader(),
+		Header: v1.NewCausedHeader(notification.Header),
 		Event:  notification,
 	}
-	if err := publisher.PublishEvent(app.config.Kafka.server, topic, errorEvent); err != nil {
+	if err := app.producer.PublishEvent(ctx, topic, key, errorEvent); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (app *application) sendNotification(ctx context.Context, notification v1.Notification) error {
-	app.log.Info(
+	app.log.InfoContext(ctx,
 		"Sending notfication",
 		"type", notification.Type,
 		"from", notification.From,
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// topicChangelog shares the purchase limits and the purchases on two
+// compacted topics of a single partition, so the changes of every instance
+// are ordered. Each instance reads both topics into its database, resuming
+// from the offsets recorded there.
+type topicChangelog struct {
+	producer       *kafka.Producer
+	consumer       *kafka.Consumer
+	limitsTopic    string
+	purchasesTopic string
+	limits         *purchaseLimits
+	log            *slog.Logger
+
+	mu sync.Mutex
+	// applied holds the offset of the next change to apply from each topic.
+	applied map[string]int64
+	// updated is closed and replaced whenever a change is applied.
+	updated chan struct{}
+	// ready is set once the changes published before the instance started
+	// are applied.
+	ready atomic.Bool
+}
+
+// newTopicChangelog reads the changes of limitsTopic and purchasesTopic
+// into limits with the client config cm, and publishes with producer.
+func newTopicChangelog(cm *kafka.ConfigMap, producer *kafka.Producer, limitsTopic, purchasesTopic string, limits *purchaseLimits, log *slog.Logger) (*topicChangelog, error) {
+	// Every instance reads all of both topics, it joins no group and
+	// commits no offsets. The group id is required all the same.
+	for key, value := range map[string]kafka.ConfigValue{
+		"group.id":           serviceName + "-changelog",
+		"enable.auto.commit": false,
+		"auto.offset.reset":  "earliest",
+	} {
+		if err := cm.SetKey(key, value); err != nil {
+			return nil, err
+		}
+	}
+	c, err := kafka.NewConsumer(cm)
+	if err != nil {
+		return nil, err
+	}
+	l := &topicChangelog{
+		producer:       producer,
+		consumer:       c,
+		limitsTopic:    limitsTopic,
+		purchasesTopic: purchasesTopic,
+		limits:         limits,
+		log:            log,
+		applied:        make(map[string]int64),
+		updated:        make(chan struct{}),
+	}
+
+	var partitions []kafka.TopicPartition
+	for _, topic := range []string{limitsTopic, purchasesTopic} {
+		topic := topic
+		offset, ok, err := limits.offset(topic)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		start := kafka.OffsetBeginning
+		if ok {
+			start = kafka.Offset(offset)
+		}
+		l.applied[topic] = offset
+		partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: start})
+	}
+	if err := c.Assign(partitions); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// topic returns the topic of a change, by the key prefix.
+func (l *topicChangelog) topic(key []byte) string {
+	if strings.HasPrefix(string(key), purchasePrefix) {
+		return l.purchasesTopic
+	}
+	return l.limitsTopic
+}
+
+func (l *topicChangelog) publish(ctx context.Context, changes ...change) (int64, error) {
+	delivery := make(chan kafka.Event, len(changes))
+	for _, c := range changes {
+		topic := l.topic(c.key)
+		msg := &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0},
+			Key:            c.key,
+			Value:          c.value,
+		}
+		if err := l.producer.Produce(msg, delivery); err != nil {
+			return 0, fmt.Errorf("publish change: %w", err)
+		}
+	}
+
+	// The highest offset delivered to each topic.
+	delivered := make(map[string]int64)
+	var last int64
+	for range changes {
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("publish change: %w", ctx.Err())
+		case e := <-delivery:
+			m, ok := e.(*kafka.Message)
+			if !ok {
+				return 0, fmt.Errorf("publish change: unexpected delivery event %v", e)
+			}
+			if m.TopicPartition.Error != nil {
+				return 0, fmt.Errorf("publish change: %w", m.TopicPartition.Error)
+			}
+			offset := int64(m.TopicPartition.Offset)
+			delivered[*m.TopicPartition.Topic] = max(delivered[*m.TopicPartition.Topic], offset)
+			last = max(last, offset)
+		}
+	}
+	for topic, offset := range delivered {
+		if err := l.wait(ctx, topic, offset); err != nil {
+			return 0, err
+		}
+	}
+	return last, nil
+}
+
+// wait returns once the change at offset of topic is applied.
+func (l *topicChangelog) wait(ctx context.Context, topic string, offset int64) error {
+	for {
+		l.mu.Lock()
+		applied, updated := l.applied[topic], l.updated
+		l.mu.Unlock()
+		if applied > offset {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("applying change: %w", ctx.Err())
+		case <-updated:
+		}
+	}
+}
+
+// Run applies the changes published by every instance until ctx is done.
+func (l *topicChangelog) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		switch e := l.consumer.Poll(100).(type) {
+		case *kafka.Message:
+			topic, offset := *e.TopicPartition.Topic, int64(e.TopicPartition.Offset)
+			// A change that can't be applied is skipped, the others don't
+			// depend on it.
+			if err := l.limits.apply(topic, offset, change{key: e.Key, value: e.Value}); err != nil {
+				l.log.Error("Applying purchase limits change", "topic", topic, "offset", offset, "key", string(e.Key), "error", err)
+			}
+			l.mu.Lock()
+			l.applied[topic] = offset + 1
+			close(l.updated)
+			l.updated = make(chan struct{})
+			l.mu.Unlock()
+		case kafka.Error:
+			if e.IsFatal() {
+				return e
+			}
+			l.log.Error("changelog", "code", e.Code(), "error", e)
+		}
+	}
+	return nil
+}
+
+// Check is a readiness check failing until the changes published before the
+// instance started are applied, so it doesn't take orders without knowing
+// the purchases of the others.
+func (l *topicChangelog) Check(ctx context.Context) error {
+	if l.ready.Load() {
+		return nil
+	}
+	timeout := 3 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	for _, topic := range []string{l.limitsTopic, l.purchasesTopic} {
+		_, high, err := l.consumer.QueryWatermarkOffsets(topic, 0, int(timeout.Milliseconds()))
+		if err != nil {
+			return err
+		}
+		l.mu.Lock()
+		applied := l.applied[topic]
+		l.mu.Unlock()
+		if applied < high {
+			return fmt.Errorf("%s applied up to offset %d of %d", topic, applied, high)
+		}
+	}
+	l.ready.Store(true)
+	return nil
+}
+
+// Close stops reading changes.
+func (l *topicChangelog) Close() {
+	if err := l.consumer.Close(); err != nil {
+		l.log.Error("closing changelog", "error", err)
+	}
+}
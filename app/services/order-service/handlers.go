@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/auth"
 	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/ratelimit"
 	"github.com/snirkop89/ppe-ecommerce/core/validator"
 )
 
-var (
-	orderReceivedTopic = "OrderReceived"
-)
-
 func healthcheckHandler(log *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		msg := map[string]string{
@@ -21,24 +26,51 @@ func healthcheckHandler(log *slog.Logger) http.HandlerFunc {
 		}
 		err := httpio.WriteJSON(w, http.StatusOK, msg)
 		if err != nil {
-			log.Error("Writing response", "error", err)
+			log.ErrorContext(r.Context(), "Writing response", "error", err)
 		}
 	}
 }
 
+// rejectWhenDraining responds with 503 once the service is shutting down,
+// so clients retry against another instance.
+func rejectWhenDraining(draining *atomic.Bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if draining.Load() {
+				w.Header().Set("Connection", "close")
+				httpio.ServiceUnavailableResponse(w, r, "service is shutting down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 type producer interface {
-	PublishEvent(topic string, data any) error
+	PublishEventSync(ctx context.Context, topic, key string, data any) error
 }
 
-func orderCreateHandler(log *slog.Logger, producer producer) http.HandlerFunc {
+// Scopes of the operations of the API, granted to API keys and tokens.
+const (
+	scopeOrdersWrite = "orders:write"
+	scopeAdminLimits = "admin:limits"
+)
+
+// maxOrderBytes bounds order requests, far above any real order.
+const maxOrderBytes = 64 << 10
+
+// orderCreateHandler places orders within the purchase limits. customers,
+// when not nil, limits the orders of each customer email.
+func orderCreateHandler(log *slog.Logger, producer producer, customers *ratelimit.Window, limits *purchaseLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var input struct {
 			Products []v1.Product `json:"products"`
 			Customer v1.Customer  `json:"customer"`
 		}
 
-		if err := httpio.Decode(r.Body, &input); err != nil {
-			log.Error(err.Error())
+		if err := httpio.DecodeLimit(w, r, &input, maxOrderBytes); err != nil {
+			log.InfoContext(r.Context(), "invalid request body", "error", err)
+			httpio.DecodeErrorResponse(w, r, err)
 			return
 		}
 
@@ -48,26 +80,139 @@ func orderCreateHandler(log *slog.Logger, producer producer) http.HandlerFunc {
 			Customer: input.Customer,
 		}
 
+		v1.NormalizeOrder(&order)
 		v := validator.New()
 		if v1.ValidateOrder(v, &order); !v.Valid() {
-			log.With("error", v.Errors).Error("failed validating order")
-			httpio.FailedValidationResponse(w, r, v.Errors)
+			log.With("error", v.Errors()).ErrorContext(r.Context(), "failed validating order")
+			httpio.FailedValidationResponse(w, r, v.Errors())
+			return
+		}
+
+		customer := strings.ToLower(order.Customer.Email)
+		if customers != nil {
+			if ok, retryAfter := customers.Allow(customer); !ok {
+				log.InfoContext(r.Context(), "customer over the order limit", "email", order.Customer.Email)
+				metrics.RateLimited(customers.Name)
+				httpio.TooManyRequestsResponse(w, r, retryAfter, "too many orders for this customer, retry later")
+				return
+			}
+		}
+		// Only placed orders count against the customer's order limit.
+		refund := func() {
+			if customers != nil {
+				customers.Refund(customer)
+			}
+		}
+
+		if err := limits.reserve(r.Context(), v, order); err != nil {
+			log.ErrorContext(r.Context(), "checking purchase limits", "error", err)
+			refund()
+			httpio.InternalServerErrorResponse(w, r, "the order could not be placed, try again later")
+			return
+		}
+		if !v.Valid() {
+			log.InfoContext(r.Context(), "order over purchase limits", "error", v.Errors())
+			refund()
+			httpio.FailedValidationResponse(w, r, v.Errors())
 			return
 		}
 
-		err := producer.PublishEvent(orderReceivedTopic, order.ToOrderReceivedEvent())
+		// The request ID correlates every event caused by this order.
+		var placedBy *v1.Principal
+		if p, ok := auth.FromContext(r.Context()); ok {
+			placedBy = &v1.Principal{Subject: p.Subject, Method: p.Method}
+		}
+		event := order.ToOrderReceivedEvent(middleware.GetReqID(r.Context()), placedBy)
+		err := producer.PublishEventSync(r.Context(), v1.OrderReceivedTopic, order.OrderID, event)
 		if err != nil {
-			log.Error(err.Error())
-			httpio.InternalServerErrorResponse(w, err.Error())
+			log.ErrorContext(r.Context(), err.Error())
+			// The order wasn't placed, it must not count against the limits.
+			if err := limits.release(r.Context(), order); err != nil {
+				log.ErrorContext(r.Context(), "releasing purchases", "error", err)
+			}
+			refund()
+			httpio.InternalServerErrorResponse(w, r, "the order could not be placed, try again later")
 			return
 		}
+		log.InfoContext(r.Context(), "Order received", "order_id", order.OrderID, "event_id", event.Header.ID)
 
 		err = httpio.WriteJSON(w, http.StatusAccepted, map[string]any{
-			"message": "order accepted",
+			"message":       "order accepted",
+			"orderId":       order.OrderID,
+			"correlationId": event.Header.CorrelationID,
 		})
 		if err != nil {
-			log.Error(err.Error())
+			log.ErrorContext(r.Context(), err.Error())
 			w.WriteHeader(500)
 		}
 	}
 }
+
+func listPurchaseLimitsHandler(log *slog.Logger, limits *purchaseLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := limits.list(r.Context())
+		if err != nil {
+			log.ErrorContext(r.Context(), "listing purchase limits", "error", err)
+			httpio.InternalServerErrorResponse(w, r, "the purchase limits could not be read")
+			return
+		}
+		if err := httpio.WriteJSON(w, http.StatusOK, map[string]any{"limits": all}); err != nil {
+			log.ErrorContext(r.Context(), "Writing response", "error", err)
+		}
+	}
+}
+
+// putPurchaseLimitHandler sets the purchase limit of the product in the
+// path, replacing any it had. It applies to the next orders.
+func putPurchaseLimitHandler(log *slog.Logger, limits *purchaseLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			MaxQuantity int `json:"maxQuantity"`
+			PeriodDays  int `json:"periodDays"`
+		}
+		if err := httpio.Decode(w, r, &input); err != nil {
+			httpio.DecodeErrorResponse(w, r, err)
+			return
+		}
+
+		limit := purchaseLimit{
+			ProductID:   v1.NormalizeProductID(chi.URLParam(r, "productId")),
+			MaxQuantity: input.MaxQuantity,
+			PeriodDays:  input.PeriodDays,
+		}
+		v := validator.New()
+		if validatePurchaseLimit(v, limit); !v.Valid() {
+			httpio.FailedValidationResponse(w, r, v.Errors())
+			return
+		}
+
+		if err := limits.put(r.Context(), limit); err != nil {
+			log.ErrorContext(r.Context(), "setting purchase limit", "error", err)
+			httpio.InternalServerErrorResponse(w, r, "the purchase limit could not be set")
+			return
+		}
+		log.InfoContext(r.Context(), "Purchase limit set", "product_id", limit.ProductID,
+			"max_quantity", limit.MaxQuantity, "period_days", limit.PeriodDays)
+		if err := httpio.WriteJSON(w, http.StatusOK, limit); err != nil {
+			log.ErrorContext(r.Context(), "Writing response", "error", err)
+		}
+	}
+}
+
+func deletePurchaseLimitHandler(log *slog.Logger, limits *purchaseLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		productID := v1.NormalizeProductID(chi.URLParam(r, "productId"))
+		err := limits.delete(r.Context(), productID)
+		if errors.Is(err, errLimitNotFound) {
+			httpio.NotFoundHandler(w, r)
+			return
+		}
+		if err != nil {
+			log.ErrorContext(r.Context(), "deleting purchase limit", "error", err)
+			httpio.InternalServerErrorResponse(w, r, "the purchase limit could not be deleted")
+			return
+		}
+		log.InfoContext(r.Context(), "Purchase limit deleted", "product_id", productID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
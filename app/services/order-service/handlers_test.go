@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/snirkop89/ppe-ecommerce/core/ratelimit"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestPurchaseLimitHandlers(t *testing.T) {
+	log := testLogger()
+	limits, _ := newTestLimits(t)
+	r := chi.NewRouter()
+	r.Get("/limits", listPurchaseLimitsHandler(log, limits))
+	r.Put("/limits/{productId}", putPurchaseLimitHandler(log, limits))
+	r.Delete("/limits/{productId}", deletePurchaseLimitHandler(log, limits))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{"empty list", "GET", "/limits", "", http.StatusOK, `{"limits":[]}`},
+		{"put", "PUT", "/limits/" + strings.ToUpper(rationedID), `{"maxQuantity": 3, "periodDays": 7}`, http.StatusOK,
+			`{"productId":"` + rationedID + `","maxQuantity":3,"periodDays":7}`},
+		{"replace", "PUT", "/limits/" + rationedID, `{"maxQuantity": 2, "periodDays": 30}`, http.StatusOK,
+			`{"productId":"` + rationedID + `","maxQuantity":2,"periodDays":30}`},
+		{"list", "GET", "/limits", "", http.StatusOK,
+			`{"limits":[{"productId":"` + rationedID + `","maxQuantity":2,"periodDays":30}]}`},
+		{"invalid product ID", "PUT", "/limits/42", `{"maxQuantity": 3, "periodDays": 7}`, http.StatusUnprocessableEntity, ""},
+		{"no quantity", "PUT", "/limits/" + freeID, `{"periodDays": 7}`, http.StatusUnprocessableEntity, ""},
+		{"period too long", "PUT", "/limits/" + freeID, `{"maxQuantity": 3, "periodDays": 366}`, http.StatusUnprocessableEntity, ""},
+		{"unknown field", "PUT", "/limits/" + freeID, `{"maxQuantity": 3, "periodDays": 7, "max": 1}`, http.StatusBadRequest, ""},
+		{"delete", "DELETE", "/limits/" + strings.ToUpper(rationedID), "", http.StatusNoContent, ""},
+		{"delete again", "DELETE", "/limits/" + rationedID, "", http.StatusNotFound, ""},
+		{"empty again", "GET", "/limits", "", http.StatusOK, `{"limits":[]}`},
+	}
+	for _, tt := range tests {
+		rec := serve(r, tt.method, tt.target, tt.body)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.want != "" && got != tt.want {
+			t.Errorf("%s: got body %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+type fakeProducer struct {
+	err    error
+	events int
+}
+
+func (p *fakeProducer) PublishEventSync(ctx context.Context, topic, key string, data any) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events++
+	return nil
+}
+
+func TestOrderCreateHandler(t *testing.T) {
+	now := time.Now()
+	order := func(quantity int) string {
+		data, _ := json.Marshal(map[string]any{
+			"products": []any{map[string]any{"productId": strings.ToUpper(rationedID), "quantity": quantity}},
+			"customer": map[string]any{
+				"firstName":    "Bruce",
+				"lastName":     "Wayne",
+				"emailAddress": "bruce@wayne.com",
+				"shippingAddress": map[string]any{
+					"street": "1007 Mountain Dr.", "city": "Gotham", "state": "NJ", "postalCode": "07001",
+				},
+			},
+		})
+		return string(data)
+	}
+
+	producer := &fakeProducer{}
+	customers := ratelimit.NewWindow("customer", 2, time.Hour)
+	h := orderCreateHandler(testLogger(), producer, customers, testLimits(t, &now))
+
+	tests := []struct {
+		name        string
+		body        string
+		brokerError error
+		status      int
+	}{
+		{"over the purchase limit", order(4), nil, http.StatusUnprocessableEntity},
+		{"broker down", order(1), errors.New("broker down"), http.StatusInternalServerError},
+		// Neither order above counts against the limits.
+		{"placed", order(3), nil, http.StatusAccepted},
+		{"limit used up", order(1), nil, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		producer.err = tt.brokerError
+		rec := serve(h, "POST", "/v1/orders", tt.body)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: got status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+	if producer.events != 1 {
+		t.Errorf("got %d events published, want 1", producer.events)
+	}
+
+	// The placed order is the only one counted for the customer.
+	if ok, _ := customers.Allow("bruce@wayne.com"); !ok {
+		t.Error("got the customer over the order limit, want a single order counted")
+	}
+	if ok, _ := customers.Allow("bruce@wayne.com"); ok {
+		t.Error("got the customer within the order limit after 2 orders")
+	}
+}
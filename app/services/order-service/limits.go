@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"github.com/snirkop89/ppe-ecommerce/core/validator"
+)
+
+// Key prefixes of the purchase limit data in the database.
+const (
+	limitPrefix    = "limit/"
+	purchasePrefix = "purchase/"
+	// offsetPrefix keys the offset of the next change to apply from each
+	// source of changes, see apply.
+	offsetPrefix = "offset/"
+)
+
+var errLimitNotFound = errors.New("purchase limit not found")
+
+// purchaseLimit rations a product: a customer can buy at most MaxQuantity
+// units of it every PeriodDays days.
+type purchaseLimit struct {
+	ProductID   string `json:"productId"`
+	MaxQuantity int    `json:"maxQuantity"`
+	PeriodDays  int    `json:"periodDays"`
+}
+
+func (l purchaseLimit) period() time.Duration {
+	return time.Duration(l.PeriodDays) * 24 * time.Hour
+}
+
+func validatePurchaseLimit(v *validator.Validator, l purchaseLimit) {
+	v.UUID("productId", l.ProductID)
+	v.Min("maxQuantity", l.MaxQuantity, 1)
+	v.Range("periodDays", l.PeriodDays, 1, 365)
+}
+
+// purchase is a product bought by a customer, recorded to count against
+// the limit of the product.
+type purchase struct {
+	Quantity int       `json:"quantity"`
+	At       time.Time `json:"at"`
+	// Expires is when the purchase no longer counts against the limit it
+	// was bought under.
+	Expires time.Time `json:"expires"`
+	// Offset orders the purchases of every instance, it is set when the
+	// purchase is applied.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// change sets key to value in the purchase limits of every instance, or
+// deletes it when value is empty.
+type change struct {
+	key   []byte
+	value []byte
+}
+
+// changelog shares the changes to the purchase limits and purchases
+// between the instances of the service. Each instance applies them in
+// order to its own database, see apply.
+type changelog interface {
+	// publish appends changes to the log and returns the highest offset
+	// given to them, once this instance has applied them.
+	publish(ctx context.Context, changes ...change) (int64, error)
+}
+
+// purchaseLimits enforces the purchase limits of the catalog, with the
+// purchases of each customer. Customers are identified both by email and by
+// shipping address, so a new email doesn't reset a limit.
+//
+// Limits and purchases are changed through the changelog shared by every
+// instance, and read from db where the instance applies the changes. Product
+// IDs are matched in any case.
+type purchaseLimits struct {
+	db  *badger.DB
+	log changelog
+	// now returns the current time, time.Now when nil.
+	now func() time.Time
+}
+
+func (pl *purchaseLimits) clock() time.Time {
+	if pl.now != nil {
+		return pl.now()
+	}
+	return time.Now()
+}
+
+func (pl *purchaseLimits) list(ctx context.Context) ([]purchaseLimit, error) {
+	_, span := tracing.Start(ctx, "badger.listPurchaseLimits")
+	defer span.End()
+
+	limits := []purchaseLimit{}
+	err := pl.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(limitPrefix), PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var l purchaseLimit
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &l)
+			})
+			if err != nil {
+				return err
+			}
+			limits = append(limits, l)
+		}
+		return nil
+	})
+	tracing.RecordError(span, err)
+	return limits, err
+}
+
+func (pl *purchaseLimits) put(ctx context.Context, l purchaseLimit) error {
+	ctx, span := tracing.Start(ctx, "changelog.putPurchaseLimit")
+	defer span.End()
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	_, err = pl.log.publish(ctx, change{key: limitKey(l.ProductID), value: data})
+	tracing.RecordError(span, err)
+	return err
+}
+
+// delete removes the limit of a product, returning errLimitNotFound when
+// it has none.
+func (pl *purchaseLimits) delete(ctx context.Context, productID string) error {
+	ctx, span := tracing.Start(ctx, "changelog.deletePurchaseLimit")
+	defer span.End()
+
+	err := pl.db.View(func(txn *badger.Txn) error {
+		_, ok, err := getLimit(txn, productID)
+		if err == nil && !ok {
+			return errLimitNotFound
+		}
+		return err
+	})
+	if err == nil {
+		_, err = pl.log.publish(ctx, change{key: limitKey(productID)})
+	}
+	if !errors.Is(err, errLimitNotFound) {
+		tracing.RecordError(span, err)
+	}
+	return err
+}
+
+// reserve records the purchases of order when they are within the limits
+// of its products. Otherwise each product over its limit is reported to v
+// and nothing is recorded. The purchases must be released if the order is
+// not placed after all.
+func (pl *purchaseLimits) reserve(ctx context.Context, v *validator.Validator, order v1.Order) error {
+	ctx, span := tracing.Start(ctx, "changelog.reservePurchases")
+	defer span.End()
+
+	now := pl.clock()
+	within, err := pl.check(v, order, now, math.MaxInt64)
+	if err != nil || !v.Valid() {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	var changes []change
+	for _, l := range within {
+		quantity := 0
+		for _, p := range order.Products {
+			if v1.NormalizeProductID(p.ProductID) == v1.NormalizeProductID(l.ProductID) {
+				quantity += p.Quantity
+			}
+		}
+		data, err := json.Marshal(purchase{Quantity: quantity, At: now, Expires: now.Add(l.period())})
+		if err != nil {
+			return err
+		}
+		for _, customer := range customerKeys(order.Customer) {
+			changes = append(changes, change{key: purchaseKey(customer, l.ProductID, order.OrderID), value: data})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	last, err := pl.log.publish(ctx, changes...)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	// Orders reserved at once by other instances were not counted above.
+	// The purchases published first win: these ones are checked again with
+	// every purchase published up to them, the other instances see these
+	// ones when checking theirs.
+	if _, err := pl.check(v, order, now, last); err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+	if !v.Valid() {
+		return pl.release(ctx, order)
+	}
+	return nil
+}
+
+// check reports to v each product of order over its limit, counting the
+// purchases of other orders published up to offset until. It returns the
+// limits of the other products.
+func (pl *purchaseLimits) check(v *validator.Validator, order v1.Order, now time.Time, until int64) ([]purchaseLimit, error) {
+	// A product may be listed several times, its quantities add up and
+	// are reported at its first listing.
+	quantities := make(map[string]int)
+	first := make(map[string]int)
+	for i, p := range order.Products {
+		id := v1.NormalizeProductID(p.ProductID)
+		if _, ok := first[id]; !ok {
+			first[id] = i
+		}
+		quantities[id] += p.Quantity
+	}
+
+	customers := customerKeys(order.Customer)
+	var within []purchaseLimit
+	err := pl.db.View(func(txn *badger.Txn) error {
+		for i, p := range order.Products {
+			id := v1.NormalizeProductID(p.ProductID)
+			if first[id] != i {
+				continue
+			}
+			l, ok, err := getLimit(txn, id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			// The customer is the one of their keys with the most purchases.
+			bought := 0
+			for _, customer := range customers {
+				n, err := purchased(txn, customer, id, order.OrderID, now.Add(-l.period()), until)
+				if err != nil {
+					return err
+				}
+				bought = max(bought, n)
+			}
+			if bought+quantities[id] > l.MaxQuantity {
+				v.Element("products", i).AddError("quantity", validator.CodeLimitExceeded,
+					fmt.Sprintf("exceeds the limit of %d per customer every %d days, %d left", l.MaxQuantity, l.PeriodDays, max(l.MaxQuantity-bought, 0)))
+				continue
+			}
+			within = append(within, l)
+		}
+		return nil
+	})
+	return within, err
+}
+
+// release removes the purchases recorded by reserve for order.
+func (pl *purchaseLimits) release(ctx context.Context, order v1.Order) error {
+	ctx, span := tracing.Start(ctx, "changelog.releasePurchases")
+	defer span.End()
+
+	var changes []change
+	for _, customer := range customerKeys(order.Customer) {
+		for _, p := range order.Products {
+			changes = append(changes, change{key: purchaseKey(customer, p.ProductID, order.OrderID)})
+		}
+	}
+	_, err := pl.log.publish(ctx, changes...)
+	tracing.RecordError(span, err)
+	return err
+}
+
+// apply writes a change read at offset from source, a log of changes, to
+// the database. Changes must be applied in the order of their offsets;
+// the offset of the next one is recorded with each, see offset.
+func (pl *purchaseLimits) apply(source string, offset int64, c change) error {
+	return pl.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(offsetKey(source), []byte(strconv.FormatInt(offset+1, 10))); err != nil {
+			return err
+		}
+		if len(c.value) == 0 {
+			return txn.Delete(c.key)
+		}
+		if !strings.HasPrefix(string(c.key), purchasePrefix) {
+			return txn.Set(c.key, c.value)
+		}
+
+		var p purchase
+		if err := json.Unmarshal(c.value, &p); err != nil {
+			return err
+		}
+		// Purchases are forgotten once they no longer count.
+		ttl := p.Expires.Sub(pl.clock())
+		if ttl <= 0 {
+			return txn.Delete(c.key)
+		}
+		p.Offset = offset
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry(c.key, data).WithTTL(ttl))
+	})
+}
+
+// offset returns the offset of the next change to apply from source, false
+// when none was applied yet.
+func (pl *purchaseLimits) offset(source string) (int64, bool, error) {
+	var offset int64
+	err := pl.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(offsetKey(source))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			offset, err = strconv.ParseInt(string(val), 10, 64)
+			return err
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, false, nil
+	}
+	return offset, err == nil, err
+}
+
+func getLimit(txn *badger.Txn, productID string) (purchaseLimit, bool, error) {
+	var l purchaseLimit
+	item, err := txn.Get(limitKey(productID))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return l, false, nil
+	}
+	if err != nil {
+		return l, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &l)
+	})
+	return l, err == nil, err
+}
+
+// purchased returns the units of a product the customer bought since, in
+// the purchases published up to offset until other than those of order
+// orderID.
+func purchased(txn *badger.Txn, customer, productID, orderID string, since time.Time, until int64) (int, error) {
+	prefix := purchaseKey(customer, productID, "")
+	own := string(purchaseKey(customer, productID, orderID))
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true})
+	defer it.Close()
+
+	total := 0
+	for it.Rewind(); it.Valid(); it.Next() {
+		if string(it.Item().Key()) == own {
+			continue
+		}
+		var p purchase
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &p)
+		})
+		if err != nil {
+			return 0, err
+		}
+		if !p.At.Before(since) && p.Offset <= until {
+			total += p.Quantity
+		}
+	}
+	return total, nil
+}
+
+func limitKey(productID string) []byte {
+	return []byte(limitPrefix + v1.NormalizeProductID(productID))
+}
+
+// purchaseKey returns the key of a purchase. The customer is escaped, as
+// addresses may contain the separator.
+func purchaseKey(customer, productID, orderID string) []byte {
+	return []byte(purchasePrefix + url.PathEscape(customer) + "/" + v1.NormalizeProductID(productID) + "/" + orderID)
+}
+
+func offsetKey(source string) []byte {
+	return []byte(offsetPrefix + source)
+}
+
+// customerKeys identifies the customer of an order by email and by
+// shipping address, both normalized.
+func customerKeys(c v1.Customer) []string {
+	a := c.ShippingAddress
+	address := strings.Join([]string{a.Country, a.PostalCode, a.Street, a.Street2}, "|")
+	return []string{
+		"email:" + strings.ToLower(c.Email),
+		"address:" + strings.ToLower(strings.Join(strings.Fields(address), " ")),
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/validator"
+)
+
+const (
+	rationedID = "6bc91dc9-b1f1-48c8-9dea-e600470dfb95"
+	freeID     = "0f5a7a8e-3c2d-4b8e-9a41-2d7c1f0e6b53"
+)
+
+func openTestDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// memoryChangelog applies the changes published to it at once, as the only
+// instance of the service.
+type memoryChangelog struct {
+	limits *purchaseLimits
+	next   int64
+	// before is called before the changes are published, i.e to publish
+	// those of another instance first.
+	before func()
+}
+
+func (l *memoryChangelog) publish(ctx context.Context, changes ...change) (int64, error) {
+	if l.before != nil {
+		before := l.before
+		l.before = nil
+		before()
+	}
+	for _, c := range changes {
+		if err := l.limits.apply("memory", l.next, c); err != nil {
+			return 0, err
+		}
+		l.next++
+	}
+	return l.next - 1, nil
+}
+
+// newTestLimits returns purchase limits without any limit, published to a
+// memoryChangelog.
+func newTestLimits(t *testing.T) (*purchaseLimits, *memoryChangelog) {
+	t.Helper()
+	pl := &purchaseLimits{db: openTestDB(t)}
+	changes := &memoryChangelog{limits: pl}
+	pl.log = changes
+	return pl, changes
+}
+
+// testLimits returns purchase limits rationing rationedID to 3 units every
+// 7 days, at the time returned by now.
+func testLimits(t *testing.T, now *time.Time) *purchaseLimits {
+	t.Helper()
+	pl, _ := newTestLimits(t)
+	pl.now = func() time.Time { return *now }
+	if err := pl.put(context.Background(), purchaseLimit{ProductID: rationedID, MaxQuantity: 3, PeriodDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	return pl
+}
+
+func testOrder(email string, products ...v1.Product) v1.Order {
+	return v1.Order{
+		OrderID:  uuid.NewString(),
+		Products: products,
+		Customer: v1.Customer{
+			FirstName: "Bruce",
+			LastName:  "Wayne",
+			Email:     email,
+			ShippingAddress: v1.Address{
+				Street:     "1007 Mountain Dr.",
+				City:       "Gotham",
+				State:      "NJ",
+				PostalCode: "07001",
+				Country:    "US",
+			},
+		},
+	}
+}
+
+func TestReserve(t *testing.T) {
+	type attempt struct {
+		order v1.Order
+		// after is the time since the previous attempt.
+		after time.Duration
+		// errors are the fields over their limit, none when the order is
+		// reserved.
+		errors []string
+	}
+	bruce := "bruce@wayne.com"
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{
+			name: "within the limit",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+			},
+		},
+		{
+			name: "over the limit at once",
+			attempts: []attempt{
+				{
+					order:  testOrder(bruce, v1.Product{ProductID: freeID, Quantity: 10}, v1.Product{ProductID: rationedID, Quantity: 4}),
+					errors: []string{"products[1].quantity"},
+				},
+			},
+		},
+		{
+			name: "over the limit across orders",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 2})},
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 2}), errors: []string{"products[0].quantity"}},
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 1})},
+			},
+		},
+		{
+			name: "listings of a product add up",
+			attempts: []attempt{
+				{
+					order:  testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 2}, v1.Product{ProductID: rationedID, Quantity: 2}),
+					errors: []string{"products[0].quantity"},
+				},
+			},
+		},
+		{
+			name: "product ID in upper case",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 2})},
+				{order: testOrder(bruce, v1.Product{ProductID: strings.ToUpper(rationedID), Quantity: 2}), errors: []string{"products[0].quantity"}},
+			},
+		},
+		{
+			name: "new email, same address",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+				{order: testOrder("batman@wayne.com", v1.Product{ProductID: rationedID, Quantity: 1}), errors: []string{"products[0].quantity"}},
+			},
+		},
+		{
+			name: "email in another case",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+				{order: testOrder("Bruce@Wayne.com", v1.Product{ProductID: rationedID, Quantity: 1}), errors: []string{"products[0].quantity"}},
+			},
+		},
+		{
+			name: "rejected orders are not recorded",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 4}), errors: []string{"products[0].quantity"}},
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+			},
+		},
+		{
+			name: "purchases expire",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+				{after: 7*24*time.Hour - time.Second, order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 1}), errors: []string{"products[0].quantity"}},
+				{after: 2 * time.Second, order: testOrder(bruce, v1.Product{ProductID: rationedID, Quantity: 3})},
+			},
+		},
+		{
+			name: "products without a limit",
+			attempts: []attempt{
+				{order: testOrder(bruce, v1.Product{ProductID: freeID, Quantity: 100})},
+				{order: testOrder(bruce, v1.Product{ProductID: freeID, Quantity: 100})},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			pl := testLimits(t, &now)
+			for i, a := range tt.attempts {
+				now = now.Add(a.after)
+				v := validator.New()
+				if err := pl.reserve(context.Background(), v, a.order); err != nil {
+					t.Fatal(err)
+				}
+				var fields []string
+				for _, e := range v.Errors() {
+					if e.Code != validator.CodeLimitExceeded {
+						t.Errorf("order %d: got code %s, want %s", i, e.Code, validator.CodeLimitExceeded)
+					}
+					fields = append(fields, e.Field)
+				}
+				if !reflect.DeepEqual(fields, a.errors) {
+					t.Fatalf("order %d: got errors at %v, want %v", i, fields, a.errors)
+				}
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	now := time.Now()
+	pl := testLimits(t, &now)
+	ctx := context.Background()
+
+	order := testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 3})
+	v := validator.New()
+	if err := pl.reserve(ctx, v, order); err != nil || !v.Valid() {
+		t.Fatalf("reserving: %v %v", err, v.Errors())
+	}
+	if err := pl.release(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	// The released units can be bought again.
+	v = validator.New()
+	if err := pl.reserve(ctx, v, testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 3})); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid() {
+		t.Errorf("got errors %v after release", v.Errors())
+	}
+}
+
+func TestReserveRace(t *testing.T) {
+	now := time.Now()
+	pl := testLimits(t, &now)
+	ctx := context.Background()
+
+	// Another instance reserves units of the same customer after this one
+	// checked the limit, and publishes them first.
+	first := testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 2})
+	pl.log.(*memoryChangelog).before = func() {
+		data, _ := json.Marshal(purchase{Quantity: 2, At: now, Expires: now.Add(7 * 24 * time.Hour)})
+		for _, customer := range customerKeys(first.Customer) {
+			if _, err := pl.log.publish(ctx, change{key: purchaseKey(customer, rationedID, first.OrderID), value: data}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	second := testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 2})
+	v := validator.New()
+	if err := pl.reserve(ctx, v, second); err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid() {
+		t.Fatal("got the order published second reserved over the limit")
+	}
+
+	// It is not recorded, the order published first keeps its units.
+	v = validator.New()
+	if err := pl.reserve(ctx, v, testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 1})); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid() {
+		t.Errorf("got errors %v, want the released units available", v.Errors())
+	}
+	v = validator.New()
+	if err := pl.reserve(ctx, v, testOrder("bruce@wayne.com", v1.Product{ProductID: rationedID, Quantity: 1})); err != nil {
+		t.Fatal(err)
+	}
+	if v.Valid() {
+		t.Error("got an order over the limit reserved")
+	}
+}
+
+func TestApplyOffset(t *testing.T) {
+	pl, _ := newTestLimits(t)
+	if _, ok, err := pl.offset("limits"); err != nil || ok {
+		t.Fatalf("got offset %t, %v before any change, want none", ok, err)
+	}
+	data, _ := json.Marshal(purchaseLimit{ProductID: rationedID, MaxQuantity: 1, PeriodDays: 1})
+	if err := pl.apply("limits", 41, change{key: limitKey(rationedID), value: data}); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok, err := pl.offset("limits"); err != nil || !ok || offset != 42 {
+		t.Errorf("got offset %d, %t, %v, want 42", offset, ok, err)
+	}
+
+	// Purchases that no longer count are not recorded.
+	expired, _ := json.Marshal(purchase{Quantity: 1, At: time.Now().Add(-48 * time.Hour), Expires: time.Now().Add(-24 * time.Hour)})
+	if err := pl.apply("purchases", 0, change{key: purchaseKey("email:bruce@wayne.com", rationedID, "1"), value: expired}); err != nil {
+		t.Fatal(err)
+	}
+	err := pl.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(purchaseKey("email:bruce@wayne.com", rationedID, "1"))
+		return err
+	})
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("got %v, want the expired purchase not found", err)
+	}
+}
@@ -1,69 +1,275 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/dgraph-io/badger/v4"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/auth"
+	"github.com/snirkop89/ppe-ecommerce/core/config"
+	"github.com/snirkop89/ppe-ecommerce/core/health"
+	"github.com/snirkop89/ppe-ecommerce/core/httpio"
 	"github.com/snirkop89/ppe-ecommerce/core/logger"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
 	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/ratelimit"
+	"github.com/snirkop89/ppe-ecommerce/core/topic"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"github.com/snirkop89/ppe-ecommerce/schemas"
+	"golang.org/x/sync/errgroup"
 )
 
-type config struct {
-	addr  string
-	kafka struct {
-		server string
-	}
-}
+// serviceName identifies the service in logs and message headers.
+const serviceName = "order-service"
 
 func main() {
-	var cfg config
-	flag.StringVar(&cfg.addr, "addr", ":8080", "address to listen on, i.e 127.0.0.1:8000")
-	flag.StringVar(&cfg.kafka.server, "kafka-server", "localhost", "kafka server address")
-	flag.Parse()
-
-	if !strings.HasPrefix(cfg.addr, ":") {
-		cfg.addr = ":" + cfg.addr
+	cfg, err := config.Load(serviceName, config.Config{
+		Addr:   ":8080",
+		API:    true,
+		DBPath: filepath.Join(os.TempDir(), serviceName),
+	}, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if !strings.Contains(cfg.Addr, ":") {
+		cfg.Addr = ":" + cfg.Addr
 	}
 
-	log := logger.NewLogger("order-service")
+	log := logger.NewLogger(serviceName)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, cfg.Tracing)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer func() {
+		tCtx, tcancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer tcancel()
+		if err := shutdownTracing(tCtx); err != nil {
+			log.Error("shutting down tracing", "error", err)
+		}
+	}()
 
 	// Initialize kafka producer
-	p, err := publisher.New(&kafka.ConfigMap{
-		"bootstrap.servers": cfg.kafka.server,
-	})
+	producerConfig, err := cfg.Kafka.ProducerConfig()
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	p, err := publisher.New(producerConfig, serviceName, log)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	// Events are validated against their schemas when published and consumed.
+	registry, err := schemas.Load()
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
 	}
-	defer p.Close()
+	// Events are published in the configured codec.
+	codecs, err := schemas.Codecs(cfg.SchemaRegistry)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	encoder, err := codecs.ByFormat(cfg.Kafka.Codec)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	p.Topics = cfg.Kafka.TopicRegistry()
+	p.Schemas = registry
+	p.Codec = encoder
+	p.Envelope = cfg.Kafka.Envelope
+
+	// Create the topics the service needs, or report how existing ones
+	// differ from their specs.
+	admin, err := kafka.NewAdminClientFromProducer(p.Client)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer admin.Close()
+	provisioner := topic.NewProvisioner(admin, log, cfg.Kafka.TopicSpecs(v1.OrderReceivedTopic, v1.PurchaseLimitsTopic, v1.PurchasesTopic)...)
+	provisionCtx, provisionCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := provisioner.Ensure(provisionCtx); err != nil {
+		log.Error("Topics don't match their specs", "error", err)
+	}
+	provisionCancel()
+
+	// Open the embedded database. Holds the purchase limits of the catalog
+	// and the purchases counting against them, as read from their topics.
+	db, err := badger.Open(badger.DefaultOptions(cfg.DBPath))
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
+	metrics.RegisterBadgerSize(db)
+	limits := &purchaseLimits{db: db}
+	topics := cfg.Kafka.TopicRegistry()
+	changes, err := newTopicChangelog(cfg.Kafka.ClientConfig(), p.Client,
+		topics.Name(v1.PurchaseLimitsTopic), topics.Name(v1.PurchasesTopic), limits, log)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	limits.log = changes
+
+	authenticators, err := auth.New(cfg.Auth)
+	if err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+	if cfg.Auth.Disabled {
+		log.Warn("Authentication is disabled, every request is accepted")
+	}
+
+	// Limits are disabled when their rate is zero.
+	var ipLimiter, keyLimiter *ratelimit.Limiter
+	var customers *ratelimit.Window
+	if rl := cfg.RateLimit; rl.IPPerMinute > 0 {
+		ipLimiter = ratelimit.NewLimiter("ip", rl.IPPerMinute, rl.IPBurst)
+	}
+	if rl := cfg.RateLimit; rl.APIKeyPerMinute > 0 {
+		keyLimiter = ratelimit.NewLimiter("api_key", rl.APIKeyPerMinute, rl.APIKeyBurst)
+	}
+	if rl := cfg.RateLimit; rl.CustomerOrders > 0 {
+		customers = ratelimit.NewWindow("customer", rl.CustomerOrders, rl.CustomerWindow)
+	}
+
+	// Set once shutdown starts, to stop taking new orders while in-flight
+	// requests complete.
+	var draining atomic.Bool
+
+	checker := health.New(3 * time.Second)
+	checker.Add("kafka", health.KafkaBroker(p.Client))
+	checker.Add("outbox", health.OutboxBacklog(p.Client, cfg.MaxBacklog))
+	checker.Add("topics", provisioner.Check)
+	checker.Add("badger", health.BadgerWritable(db))
+	checker.Add("purchase-limits", changes.Check)
+	checker.Add("draining", func(ctx context.Context) error {
+		if draining.Load() {
+			return errors.New("shutting down")
+		}
+		return nil
+	})
 
 	// Setup routes
 	r := chi.NewRouter()
+	if cfg.RateLimit.TrustProxy {
+		r.Use(middleware.RealIP)
+	}
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(tracing.Middleware)
 	r.Use(logger.LoggingMiddleware(log))
+	r.NotFound(httpio.NotFoundHandler)
+	r.MethodNotAllowed(httpio.MethodNotAllowedHandler)
+
+	r.Handle("/metrics", metrics.Handler())
 
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/healthcheck", healthcheckHandler(log))
-		r.Post("/orders", orderCreateHandler(log, p))
+		r.Get("/health/live", health.LivenessHandler)
+		r.Get("/health/ready", checker.ReadinessHandler)
+
+		// Routes below require credentials, and the scopes of their
+		// operations. Clients are throttled by IP before authenticating, so
+		// guessing credentials is slow, then by API key.
+		r.Group(func(r chi.Router) {
+			if ipLimiter != nil {
+				r.Use(ratelimit.Middleware(ipLimiter, ratelimit.ByIP))
+			}
+			r.Use(auth.Middleware(authenticators...))
+			if keyLimiter != nil {
+				r.Use(ratelimit.Middleware(keyLimiter, ratelimit.ByAPIKey))
+			}
+			r.With(rejectWhenDraining(&draining), auth.RequireScope(scopeOrdersWrite)).Post("/orders", orderCreateHandler(log, p, customers, limits))
+
+			r.Route("/admin/purchase-limits", func(r chi.Router) {
+				r.Use(auth.RequireScope(scopeAdminLimits))
+				r.Get("/", listPurchaseLimitsHandler(log, limits))
+				r.Put("/{productId}", putPurchaseLimitHandler(log, limits))
+				r.Delete("/{productId}", deletePurchaseLimitHandler(log, limits))
+			})
+		})
 	})
 
 	srv := &http.Server{
-		Addr:         cfg.addr,
+		Addr:         cfg.Addr,
 		Handler:      r,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	// TODO: Graceful shutdown
-	log.Info("Starting HTTP server", "addr", srv.Addr)
-	if err := srv.ListenAndServe(); err != nil {
+	// Prepare a context to catch cancelation signals.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		return provisioner.Run(ctx, time.Minute)
+	})
+	g.Go(func() error {
+		return changes.Run(ctx)
+	})
+
+	// ######  HTTP server
+	g.Go(func() error {
+		log.Info("Starting HTTP server", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		<-ctx.Done()
+		log.Info("Received termination signal. Shutting down server")
+		draining.Store(true)
+
+		tCtx, tcancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer tcancel()
+
+		err := srv.Shutdown(tCtx)
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		log.Info("Server shutdown completed")
+		return nil
+	})
+	// ########
+
+	// Wait for any error in intialization for shutdown.
+	err = g.Wait()
+	if err != nil {
 		log.Error(err.Error())
 	}
+
+	// No requests are served anymore, stop reading changes.
+	changes.Close()
+
+	// No requests are publishing anymore, deliver what is still buffered.
+	if n := p.Shutdown(cfg.ShutdownTimeout); n > 0 {
+		log.Error("Producer closed with undelivered events", "count", n)
+		return
+	}
+	log.Info("Producer flushed")
 }
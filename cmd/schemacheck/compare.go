@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Direction of a compatibility break.
+const (
+	// Backward breaks stop consumers on the new schema from reading events
+	// published with the old one.
+	Backward = "backward"
+	// Forward breaks stop consumers still on the old schema from reading
+	// events published with the new one.
+	Forward = "forward"
+)
+
+// Change is an incompatible difference between two versions of a schema.
+type Change struct {
+	Direction string
+	EventType string
+	// Path is the JSON pointer of the changed field in the event.
+	Path    string
+	Message string
+}
+
+func (c Change) String() string {
+	p := c.Path
+	if p == "" {
+		p = "/"
+	}
+	return fmt.Sprintf("%-8s  %s %s: %s", c.Direction, c.EventType, p, c.Message)
+}
+
+// loader reads a schema document by its file name.
+type loader func(file string) ([]byte, error)
+
+// documents resolves $refs between the schema documents of one version.
+type documents struct {
+	load  loader
+	cache map[string]map[string]any
+}
+
+func newDocuments(load loader) *documents {
+	return &documents{load: load, cache: make(map[string]map[string]any)}
+}
+
+func (d *documents) document(file string) (map[string]any, error) {
+	if doc, ok := d.cache[file]; ok {
+		return doc, nil
+	}
+	data, err := d.load(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	d.cache[file] = doc
+	return doc, nil
+}
+
+// resolve follows the $ref of a schema found in file, returning the schema
+// it points to and the file holding it.
+func (d *documents) resolve(file string, schema map[string]any) (map[string]any, string, error) {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema, file, nil
+		}
+		target, pointer, _ := strings.Cut(ref, "#")
+		if target != "" {
+			file = path.Join(path.Dir(file), target)
+		}
+		doc, err := d.document(file)
+		if err != nil {
+			return nil, "", err
+		}
+		var node any = doc
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			if token == "" {
+				continue
+			}
+			m, ok := node.(map[string]any)
+			if !ok {
+				return nil, "", fmt.Errorf("%s: unresolved $ref %q", file, ref)
+			}
+			node = m[token]
+		}
+		next, ok := node.(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("%s: unresolved $ref %q", file, ref)
+		}
+		schema = next
+	}
+}
+
+// comparison collects the changes between the schemas of one event type.
+type comparison struct {
+	eventType string
+	old, new  *documents
+	changes   []Change
+}
+
+func (c *comparison) report(direction, pointer, format string, args ...any) {
+	c.changes = append(c.changes, Change{
+		Direction: direction,
+		EventType: c.eventType,
+		Path:      pointer,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// compareFiles compares the schemas of an event type in oldFile and
+// newFile.
+func compareFiles(eventType string, old, new *documents, oldFile, newFile string) ([]Change, error) {
+	oldDoc, err := old.document(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newDoc, err := new.document(newFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &comparison{eventType: eventType, old: old, new: new}
+	if err := c.compare("", oldFile, oldDoc, newFile, newDoc); err != nil {
+		return nil, err
+	}
+	return c.changes, nil
+}
+
+func (c *comparison) compare(pointer, oldFile string, oldSchema map[string]any, newFile string, newSchema map[string]any) error {
+	oldSchema, oldFile, err := c.old.resolve(oldFile, oldSchema)
+	if err != nil {
+		return err
+	}
+	newSchema, newFile, err = c.new.resolve(newFile, newSchema)
+	if err != nil {
+		return err
+	}
+
+	oldType, newType := typeName(oldSchema), typeName(newSchema)
+	if oldType != newType {
+		c.report(Backward, pointer, "type changed from %s to %s", oldType, newType)
+		c.report(Forward, pointer, "type changed from %s to %s", oldType, newType)
+		return nil
+	}
+	if oldFormat, newFormat := stringOf(oldSchema, "format"), stringOf(newSchema, "format"); oldFormat != newFormat {
+		if newFormat != "" {
+			c.report(Backward, pointer, "format changed from %q to %q", oldFormat, newFormat)
+		}
+		if oldFormat != "" {
+			c.report(Forward, pointer, "format changed from %q to %q", oldFormat, newFormat)
+		}
+	}
+	c.compareEnum(pointer, oldSchema, newSchema)
+	for _, keyword := range []string{"minimum", "minLength", "minItems"} {
+		c.compareMinimum(pointer, keyword, oldSchema, newSchema)
+	}
+
+	if oldItems, ok := oldSchema["items"].(map[string]any); ok {
+		if newItems, ok := newSchema["items"].(map[string]any); ok {
+			if err := c.compare(pointer+"/*", oldFile, oldItems, newFile, newItems); err != nil {
+				return err
+			}
+		}
+	}
+	return c.compareProperties(pointer, oldFile, oldSchema, newFile, newSchema)
+}
+
+func (c *comparison) compareProperties(pointer, oldFile string, oldSchema map[string]any, newFile string, newSchema map[string]any) error {
+	oldProps, _ := oldSchema["properties"].(map[string]any)
+	newProps, _ := newSchema["properties"].(map[string]any)
+	oldRequired, newRequired := required(oldSchema), required(newSchema)
+	oldClosed, newClosed := oldSchema["additionalProperties"] == false, newSchema["additionalProperties"] == false
+
+	for _, name := range sortedKeys(oldProps) {
+		field := pointer + "/" + name
+		newProp, ok := newProps[name]
+		if !ok {
+			if newClosed {
+				c.report(Backward, field, "field removed, events that still have it are rejected")
+			}
+			if oldRequired[name] {
+				c.report(Forward, field, "required field removed")
+			}
+			continue
+		}
+		if newRequired[name] && !oldRequired[name] {
+			c.report(Backward, field, "field became required")
+		}
+		if oldRequired[name] && !newRequired[name] {
+			c.report(Forward, field, "field is no longer required")
+		}
+		oldProp, _ := oldProps[name].(map[string]any)
+		newPropSchema, _ := newProp.(map[string]any)
+		if err := c.compare(field, oldFile, oldProp, newFile, newPropSchema); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedKeys(newProps) {
+		if _, ok := oldProps[name]; ok {
+			continue
+		}
+		field := pointer + "/" + name
+		if newRequired[name] {
+			c.report(Backward, field, "new required field")
+		}
+		if oldClosed {
+			c.report(Forward, field, "new field, rejected by consumers decoding strictly")
+		}
+	}
+	return nil
+}
+
+func (c *comparison) compareEnum(pointer string, oldSchema, newSchema map[string]any) {
+	oldEnum, oldOK := oldSchema["enum"].([]any)
+	newEnum, newOK := newSchema["enum"].([]any)
+	if !oldOK && !newOK {
+		return
+	}
+	for _, v := range oldEnum {
+		if newOK && !slices.Contains(newEnum, v) {
+			c.report(Backward, pointer, "enum value %v removed", v)
+		}
+	}
+	for _, v := range newEnum {
+		if oldOK && !slices.Contains(oldEnum, v) {
+			c.report(Forward, pointer, "enum value %v added", v)
+		}
+	}
+	if !oldOK {
+		c.report(Backward, pointer, "values restricted to an enum")
+	}
+	if !newOK {
+		c.report(Forward, pointer, "enum restriction removed")
+	}
+}
+
+// compareMinimum reports a lower bound raised, rejecting old events, or
+// lowered, producing events old consumers reject.
+func (c *comparison) compareMinimum(pointer, keyword string, oldSchema, newSchema map[string]any) {
+	oldMin, oldOK := oldSchema[keyword].(float64)
+	newMin, newOK := newSchema[keyword].(float64)
+	switch {
+	case newOK && (!oldOK || newMin > oldMin):
+		c.report(Backward, pointer, "%s raised to %v", keyword, newMin)
+	case oldOK && (!newOK || newMin < oldMin):
+		c.report(Forward, pointer, "%s lowered from %v", keyword, oldMin)
+	}
+}
+
+// typeName returns the type keyword of schema, "any" when it has none.
+func typeName(schema map[string]any) string {
+	if t, ok := schema["type"]; ok {
+		return fmt.Sprint(t)
+	}
+	return "any"
+}
+
+func stringOf(schema map[string]any, keyword string) string {
+	s, _ := schema[keyword].(string)
+	return s
+}
+
+func required(schema map[string]any) map[string]bool {
+	names, _ := schema["required"].([]any)
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		if s, ok := n.(string); ok {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// schemaFiles loads schemas from memory, keyed by file name.
+func schemaFiles(files map[string]string) *documents {
+	return newDocuments(func(file string) ([]byte, error) {
+		data, ok := files[file]
+		if !ok {
+			return nil, fmt.Errorf("%s: not found", file)
+		}
+		return []byte(data), nil
+	})
+}
+
+func TestCompareFiles(t *testing.T) {
+	const base = `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["orderId", "quantity"],
+		"properties": {
+			"orderId": {"type": "string", "format": "uuid"},
+			"quantity": {"type": "number", "minimum": 1},
+			"status": {"type": "string", "enum": ["new", "paid", "shipped"]},
+			"note": {"type": "string"}
+		}
+	}`
+
+	tests := []struct {
+		name string
+		new  string
+		want []Change
+	}{
+		{
+			name: "unchanged",
+			new:  base,
+		},
+		{
+			name: "added required field",
+			new: `{
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["orderId", "quantity", "currency"],
+				"properties": {
+					"orderId": {"type": "string", "format": "uuid"},
+					"quantity": {"type": "number", "minimum": 1},
+					"status": {"type": "string", "enum": ["new", "paid", "shipped"]},
+					"note": {"type": "string"},
+					"currency": {"type": "string"}
+				}
+			}`,
+			want: []Change{
+				{Backward, "Test", "/currency", "new required field"},
+				{Forward, "Test", "/currency", "new field, rejected by consumers decoding strictly"},
+			},
+		},
+		{
+			name: "removed field",
+			new: `{
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["quantity"],
+				"properties": {
+					"quantity": {"type": "number", "minimum": 1},
+					"status": {"type": "string", "enum": ["new", "paid", "shipped"]},
+					"note": {"type": "string"}
+				}
+			}`,
+			want: []Change{
+				{Backward, "Test", "/orderId", "field removed, events that still have it are rejected"},
+				{Forward, "Test", "/orderId", "required field removed"},
+			},
+		},
+		{
+			name: "type narrowing",
+			new: `{
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["orderId", "quantity"],
+				"properties": {
+					"orderId": {"type": "string", "format": "uuid"},
+					"quantity": {"type": "integer", "minimum": 1},
+					"status": {"type": "string", "enum": ["new", "paid", "shipped"]},
+					"note": {"type": "string"}
+				}
+			}`,
+			want: []Change{
+				{Backward, "Test", "/quantity", "type changed from number to integer"},
+				{Forward, "Test", "/quantity", "type changed from number to integer"},
+			},
+		},
+		{
+			name: "enum shrink",
+			new: `{
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["orderId", "quantity"],
+				"properties": {
+					"orderId": {"type": "string", "format": "uuid"},
+					"quantity": {"type": "number", "minimum": 1},
+					"status": {"type": "string", "enum": ["new", "paid"]},
+					"note": {"type": "string"}
+				}
+			}`,
+			want: []Change{
+				{Backward, "Test", "/status", "enum value shipped removed"},
+			},
+		},
+		{
+			name: "minimum raised through a ref",
+			new: `{
+				"type": "object",
+				"additionalProperties": false,
+				"required": ["orderId", "quantity"],
+				"properties": {
+					"orderId": {"type": "string", "format": "uuid"},
+					"quantity": {"$ref": "common.json#/definitions/quantity"},
+					"status": {"type": "string", "enum": ["new", "paid", "shipped"]},
+					"note": {"type": "string"}
+				}
+			}`,
+			want: []Change{
+				{Backward, "Test", "/quantity", "minimum raised to 2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := schemaFiles(map[string]string{"test.json": base})
+			new := schemaFiles(map[string]string{
+				"test.json":   tt.new,
+				"common.json": `{"definitions": {"quantity": {"type": "number", "minimum": 2}}}`,
+			})
+			got, err := compareFiles("Test", old, new, "test.json", "test.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got changes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareFilesUnresolvedRef(t *testing.T) {
+	old := schemaFiles(map[string]string{"test.json": `{"type": "object"}`})
+	new := schemaFiles(map[string]string{"test.json": `{"$ref": "#/definitions/missing"}`})
+	if _, err := compareFiles("Test", old, new, "test.json", "test.json"); err == nil {
+		t.Error("got no error for an unresolved $ref")
+	}
+}
@@ -0,0 +1,122 @@
+// Command schemacheck reports incompatible changes between the event
+// schemas in the working tree and a released snapshot of them, before
+// changes to api/v1 are merged.
+//
+// The snapshot is either a directory holding the released schema documents
+// or a git revision, i.e a release tag:
+//
+//	go run ./cmd/schemacheck -ref v1.2.0
+//	go run ./cmd/schemacheck -baseline /tmp/released-schemas -mode backward
+//
+// Backward incompatible changes stop upgraded consumers from reading events
+// already published, forward incompatible ones stop consumers not yet
+// upgraded from reading new events. It exits with status 1 when it finds
+// any in the checked direction.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/snirkop89/ppe-ecommerce/schemas"
+)
+
+// Modes select the directions checked, as named by schema registries.
+const (
+	modeBackward = "backward"
+	modeForward  = "forward"
+	modeFull     = "full"
+)
+
+func main() {
+	dir := flag.String("schemas", "schemas", "directory of the current schema documents")
+	baseline := flag.String("baseline", "", "directory of the released schema documents")
+	ref := flag.String("ref", "", "git revision of the released schemas, instead of -baseline")
+	mode := flag.String("mode", modeFull, "directions to check: backward, forward or full")
+	flag.Parse()
+
+	found, err := run(*dir, *baseline, *ref, *mode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "schemacheck:", err)
+		os.Exit(2)
+	}
+	if found > 0 {
+		fmt.Fprintf(os.Stderr, "schemacheck: %d incompatible changes\n", found)
+		os.Exit(1)
+	}
+}
+
+// run prints the incompatible changes in the directions of mode and
+// returns how many it found.
+func run(dir, baseline, ref, mode string) (int, error) {
+	if (baseline == "") == (ref == "") {
+		return 0, errors.New("either -baseline or -ref is required")
+	}
+	if mode != modeBackward && mode != modeForward && mode != modeFull {
+		return 0, fmt.Errorf("unknown mode %q, want backward, forward or full", mode)
+	}
+
+	current := newDocuments(func(file string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	})
+	released := newDocuments(func(file string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(baseline, filepath.FromSlash(file)))
+	})
+	if ref != "" {
+		if err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run(); err != nil {
+			return 0, fmt.Errorf("unknown git revision %q", ref)
+		}
+		released = newDocuments(gitLoader(ref))
+	}
+
+	eventTypes := make([]string, 0, len(schemas.Files))
+	for eventType := range schemas.Files {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	found := 0
+	for _, eventType := range eventTypes {
+		file := schemas.Files[eventType]
+		if _, err := released.document(file); errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("new       %s\n", eventType)
+			continue
+		}
+		changes, err := compareFiles(eventType, released, current, file, file)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range changes {
+			if mode != modeFull && c.Direction != mode {
+				continue
+			}
+			fmt.Println(c)
+			found++
+		}
+	}
+	return found, nil
+}
+
+// gitLoader reads the schema documents of the schemas package as they were
+// at revision ref.
+func gitLoader(ref string) loader {
+	return func(file string) ([]byte, error) {
+		name := path.Join("schemas", file)
+		out, err := exec.Command("git", "show", ref+":"+name).Output()
+		if err != nil {
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				return nil, fmt.Errorf("%s at %s: %w", name, ref, fs.ErrNotExist)
+			}
+			return nil, err
+		}
+		return out, nil
+	}
+}
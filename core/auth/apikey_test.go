@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	keys, err := NewAPIKeys(
+		APIKey{Name: "acme", SHA256: hashKey("acme-secret"), Scopes: []string{"orders:write"}},
+		// Hashes are accepted in upper case, as some tools print them.
+		APIKey{Name: "globex", SHA256: strings.ToUpper(hashKey("globex-secret")), Scopes: []string{"admin:limits"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		subject string
+		scopes  []string
+		err     error
+	}{
+		{name: "first key", key: "acme-secret", subject: "acme", scopes: []string{"orders:write"}},
+		{name: "upper case hash", key: "globex-secret", subject: "globex", scopes: []string{"admin:limits"}},
+		{name: "no key", err: ErrNoCredentials},
+		{name: "unknown key", key: "initech-secret", err: ErrInvalidCredentials},
+		{name: "one character off", key: "acme-secreT", err: ErrInvalidCredentials},
+		{name: "prefix of a key", key: "acme", err: ErrInvalidCredentials},
+		{name: "hash of a key", key: hashKey("acme-secret"), err: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/orders", nil)
+			if tt.key != "" {
+				r.Header.Set(HeaderAPIKey, tt.key)
+			}
+			p, err := keys.Authenticate(r)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Subject != tt.subject || p.Method != MethodAPIKey || !reflect.DeepEqual(p.Scopes, tt.scopes) {
+				t.Errorf("got principal %+v, want %s with scopes %v", p, tt.subject, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeysRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []APIKey
+	}{
+		{"no name", []APIKey{{SHA256: hashKey("a")}}},
+		{"no hash", []APIKey{{Name: "a"}}},
+		{"short hash", []APIKey{{Name: "a", SHA256: hashKey("a")[:32]}}},
+		{"not hex", []APIKey{{Name: "a", SHA256: strings.Repeat("z", 64)}}},
+		{"duplicate name", []APIKey{{Name: "a", SHA256: hashKey("a")}, {Name: "a", SHA256: hashKey("b")}}},
+		{"duplicate key", []APIKey{{Name: "a", SHA256: hashKey("a")}, {Name: "b", SHA256: strings.ToUpper(hashKey("a"))}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewAPIKeys(tt.keys...); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	data := "keys:\n  - name: acme\n    sha256: " + hashKey("acme-secret") + "\n    scopes: [orders:write]\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/v1/orders", nil)
+	r.Header.Set(HeaderAPIKey, "acme-secret")
+	p, err := keys.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "acme" || !p.HasScope("orders:write") {
+		t.Errorf("got principal %+v", p)
+	}
+}
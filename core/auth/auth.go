@@ -0,0 +1,199 @@
+// Package auth authenticates the clients of the HTTP APIs and authorizes
+// their requests by scope.
+//
+// Business integrations authenticate with API keys, sent in the X-API-Key
+// header. Storefront users authenticate with JWT bearer tokens, signed with
+// keys from a locally configured JWKS file. Both grant scopes, and routes
+// require the scopes of the operations they serve with RequireScope.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	"github.com/snirkop89/ppe-ecommerce/core/logger"
+)
+
+// Methods a principal can authenticate with.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+	// MethodNone is the method of the anonymous principal of Insecure.
+	MethodNone = "none"
+)
+
+// realm is sent in the challenges of rejected requests.
+const realm = "ppe-ecommerce"
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request has
+	// no credentials of its kind.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned when credentials are unknown,
+	// expired or forged.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	// Subject identifies the client: the name of an API key or the subject
+	// of a token.
+	Subject string
+	// Method is how the client authenticated, i.e MethodAPIKey.
+	Method string
+	Scopes []string
+
+	// anyScope is set for the anonymous principal of Insecure.
+	anyScope bool
+}
+
+// HasScope reports whether p was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return p.anyScope || slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator verifies the credentials of a request. It returns
+// ErrNoCredentials when the request carries none of the kind it handles.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok
+}
+
+// Middleware authenticates requests with the first authenticator whose
+// credentials they carry, and stores the principal in the request context.
+// Requests with invalid credentials are rejected, those without any are
+// passed on unauthenticated, for RequireScope to reject where it applies.
+func Middleware(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, a := range authenticators {
+				p, err := a.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					httpio.UnauthorizedResponse(w, r, challenge("invalid_token"), err.Error())
+					return
+				}
+				ctx := logger.WithAttrs(r.Context(), "principal", p.Subject)
+				next.ServeHTTP(w, r.WithContext(NewContext(ctx, p)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope rejects requests whose principal wasn't granted every one
+// of scopes, and unauthenticated requests.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok {
+				httpio.UnauthorizedResponse(w, r, challenge(""), "authentication is required, with an API key or a bearer token")
+				return
+			}
+			for _, scope := range scopes {
+				if !p.HasScope(scope) {
+					httpio.ForbiddenResponse(w, r, fmt.Sprintf("the %s scope is required", scope))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// challenge returns the WWW-Authenticate value of a rejected request, as
+// defined for bearer tokens by RFC 6750.
+func challenge(errorCode string) string {
+	c := fmt.Sprintf("Bearer realm=%q", realm)
+	if errorCode != "" {
+		c += fmt.Sprintf(", error=%q", errorCode)
+	}
+	return c
+}
+
+// Insecure returns an authenticator accepting every request as an anonymous
+// principal granted every scope. It is meant for local development only.
+func Insecure() Authenticator {
+	return insecure{}
+}
+
+type insecure struct{}
+
+func (insecure) Authenticate(r *http.Request) (*Principal, error) {
+	return &Principal{Subject: "anonymous", Method: MethodNone, anyScope: true}, nil
+}
+
+type Config struct {
+	// APIKeysFile is a YAML file of API keys, see LoadAPIKeys.
+	APIKeysFile string `yaml:"apiKeysFile" toml:"apiKeysFile"`
+	// JWKSFile is a JSON Web Key Set file with the keys signing bearer
+	// tokens.
+	JWKSFile string `yaml:"jwksFile" toml:"jwksFile"`
+	// Issuer and Audience, when set, must match the claims of tokens.
+	Issuer   string `yaml:"issuer" toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+	// Disabled accepts every request, see Insecure.
+	Disabled bool `yaml:"disabled" toml:"disabled"`
+}
+
+// Validate reports the settings missing for authenticating requests.
+func (cfg Config) Validate() error {
+	if !cfg.Disabled && cfg.APIKeysFile == "" && cfg.JWKSFile == "" {
+		return errors.New("auth-api-keys or auth-jwks is required, unless auth-disabled")
+	}
+	return nil
+}
+
+// New returns the authenticators configured by cfg.
+func New(cfg Config) ([]Authenticator, error) {
+	if cfg.Disabled {
+		return []Authenticator{Insecure()}, nil
+	}
+	var authenticators []Authenticator
+	if cfg.APIKeysFile != "" {
+		keys, err := LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, &JWT{Keys: keys, Issuer: cfg.Issuer, Audience: cfg.Audience})
+	}
+	return authenticators, nil
+}
+
+// bearerToken returns the token of an Authorization header using the
+// Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
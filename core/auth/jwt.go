@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256, ES256 and HS256.
+	_ "crypto/sha512" // SHA-384 and SHA-512 for the other algorithms.
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// leeway tolerates clock skew between the token issuer and the service.
+const leeway = time.Minute
+
+// Key is a key of a JSON Web Key Set verifying token signatures.
+type Key struct {
+	ID string
+	// Algorithm restricts the key to one signing algorithm, i.e RS256.
+	// Empty allows every algorithm of the key type.
+	Algorithm string
+	// Public is an *rsa.PublicKey, an *ecdsa.PublicKey or the []byte of a
+	// symmetric key.
+	Public any
+}
+
+// LoadJWKS reads the keys of a JSON Web Key Set file, RFC 7517. RSA, EC
+// and symmetric (oct) keys are supported; keys for other uses than
+// signatures are skipped.
+func LoadJWKS(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load jwks: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("load jwks %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParseJWKS parses the keys of a JSON Web Key Set.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key := Key{ID: jwk.Kid, Algorithm: jwk.Alg}
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			var n, e []byte
+			if n, err = b64(jwk.N); err == nil {
+				e, err = b64(jwk.E)
+			}
+			if err == nil && len(e) > 4 {
+				err = errors.New("exponent too large")
+			}
+			key.Public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			key.Public, err = ecKey(jwk.Crv, jwk.X, jwk.Y)
+		case "oct":
+			key.Public, err = b64(jwk.K)
+		default:
+			err = fmt.Errorf("unsupported key type %q", jwk.Kty)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d %q: %w", i, jwk.Kid, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xb, err := b64(x)
+	if err != nil {
+		return nil, err
+	}
+	yb, err := b64(y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point not on curve")
+	}
+	return key, nil
+}
+
+func b64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// JWT authenticates requests by the JSON Web Token, RFC 7519, in their
+// Authorization bearer header. Tokens must be signed by one of Keys and
+// unexpired; their scopes are read from the scope claim, space separated,
+// or the scp claim, an array.
+type JWT struct {
+	Keys []Key
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+}
+
+type claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Scp       []string `json:"scp"`
+}
+
+// audience is the aud claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	c, err := j.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	scopes := c.Scp
+	if c.Scope != "" {
+		scopes = strings.Fields(c.Scope)
+	}
+	return &Principal{Subject: c.Subject, Method: MethodJWT, Scopes: scopes}, nil
+}
+
+// verify checks the signature and claims of token, returning the claims.
+func (j *JWT) verify(token string) (*claims, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("token %s: %w", fmt.Sprintf(format, args...), ErrInvalidCredentials)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("is malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if data, err := b64(parts[0]); err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, invalid("header is malformed")
+	}
+	signature, err := b64(parts[2])
+	if err != nil {
+		return nil, invalid("signature is malformed")
+	}
+	if !j.verifySignature(header.Alg, header.Kid, parts[0]+"."+parts[1], signature) {
+		return nil, invalid("signature is invalid")
+	}
+
+	var c claims
+	if data, err := b64(parts[1]); err != nil || json.Unmarshal(data, &c) != nil {
+		return nil, invalid("claims are malformed")
+	}
+	now := time.Now()
+	if j.Now != nil {
+		now = j.Now()
+	}
+	switch {
+	case c.Subject == "":
+		return nil, invalid("has no subject")
+	case c.ExpiresAt == nil:
+		return nil, invalid("has no expiration")
+	case now.After(time.Unix(*c.ExpiresAt, 0).Add(leeway)):
+		return nil, invalid("is expired")
+	case c.NotBefore != nil && now.Add(leeway).Before(time.Unix(*c.NotBefore, 0)):
+		return nil, invalid("is not valid yet")
+	case j.Issuer != "" && c.Issuer != j.Issuer:
+		return nil, invalid("issuer %q is not trusted", c.Issuer)
+	case j.Audience != "" && !slices.Contains(c.Audience, j.Audience):
+		return nil, invalid("is not meant for %s", j.Audience)
+	}
+	return &c, nil
+}
+
+// verifySignature checks signature with the keys matching kid that can
+// sign with alg. Tokens without kid are checked with every key.
+func (j *JWT) verifySignature(alg, kid, signed string, signature []byte) bool {
+	hash, ok := map[string]crypto.Hash{
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+		"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	}[alg]
+	// Unknown algorithms, none among them, are never accepted.
+	if !ok {
+		return false
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	for _, key := range j.Keys {
+		if (kid != "" && key.ID != kid) || (key.Algorithm != "" && key.Algorithm != alg) {
+			continue
+		}
+		// The algorithm family must match the key type, so a public key
+		// can't be used as an HMAC secret.
+		var valid bool
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			switch alg[:2] {
+			case "RS":
+				valid = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+			case "PS":
+				valid = rsa.VerifyPSS(pub, hash, digest, signature, nil) == nil
+			}
+		case *ecdsa.PublicKey:
+			bits := pub.Curve.Params().BitSize
+			size := (bits + 7) / 8
+			if alg == fmt.Sprintf("ES%d", min(bits, 512)) && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				valid = ecdsa.Verify(pub, digest, r, s)
+			}
+		case []byte:
+			if alg[:2] == "HS" {
+				mac := hmac.New(hash.New, pub)
+				mac.Write([]byte(signed))
+				valid = hmac.Equal(mac.Sum(nil), signature)
+			}
+		}
+		if valid {
+			return true
+		}
+	}
+	return false
+}
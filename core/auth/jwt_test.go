@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testNow    = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rsaKey     = mustRSAKey()
+	ecKey256   = mustECKey(elliptic.P256())
+	hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func mustECKey(curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func testJWT() *JWT {
+	return &JWT{
+		Keys: []Key{
+			{ID: "rsa", Public: &rsaKey.PublicKey},
+			{ID: "ec", Algorithm: "ES256", Public: &ecKey256.PublicKey},
+			{ID: "hmac", Algorithm: "HS256", Public: hmacSecret},
+		},
+		Issuer:   "https://id.example.com",
+		Audience: "ppe-ecommerce",
+		Now:      func() time.Time { return testNow },
+	}
+}
+
+// testClaims returns valid claims, changed by the given edits.
+func testClaims(edits ...func(map[string]any)) map[string]any {
+	c := map[string]any{
+		"iss":   "https://id.example.com",
+		"sub":   "user-42",
+		"aud":   "ppe-ecommerce",
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"scope": "orders:write admin:limits",
+	}
+	for _, edit := range edits {
+		edit(c)
+	}
+	return c
+}
+
+func encodeSegment(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a token of claims signed with key by alg. The signature of
+// alg none is empty.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encodeSegment(header) + "." + encodeSegment(claims)
+
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	var signature []byte
+	if alg != "none" {
+		hash := hashes[alg[2:]]
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest := h.Sum(nil)
+
+		var err error
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if alg[:2] == "PS" {
+				signature, err = rsa.SignPSS(rand.Reader, k, hash, digest, nil)
+			} else {
+				signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+			}
+		case *ecdsa.PrivateKey:
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, k, digest)
+			size := (k.Curve.Params().BitSize + 7) / 8
+			signature = make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+		case []byte:
+			mac := hmac.New(hash.New, k)
+			mac.Write([]byte(signed))
+			signature = mac.Sum(nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authenticate(j *JWT, token string) (*Principal, error) {
+	r := httptest.NewRequest("POST", "/v1/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return j.Authenticate(r)
+}
+
+func TestJWTAccepts(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+	}{
+		{"RS256", func(t *testing.T) string { return sign(t, "RS256", "rsa", rsaKey, testClaims()) }},
+		{"RS512", func(t *testing.T) string { return sign(t, "RS512", "rsa", rsaKey, testClaims()) }},
+		{"PS256", func(t *testing.T) string { return sign(t, "PS256", "rsa", rsaKey, testClaims()) }},
+		{"ES256", func(t *testing.T) string { return sign(t, "ES256", "ec", ecKey256, testClaims()) }},
+		{"HS256", func(t *testing.T) string { return sign(t, "HS256", "hmac", hmacSecret, testClaims()) }},
+		{"no kid", func(t *testing.T) string { return sign(t, "ES256", "", ecKey256, testClaims()) }},
+		{"audience array", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+				c["aud"] = []string{"billing", "ppe-ecommerce"}
+			}))
+		}},
+		{"expired within leeway", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+				c["exp"] = testNow.Add(-leeway + time.Second).Unix()
+			}))
+		}},
+		{"not before within leeway", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+				c["nbf"] = testNow.Add(leeway - time.Second).Unix()
+			}))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := authenticate(testJWT(), tt.token(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &Principal{Subject: "user-42", Method: MethodJWT, Scopes: []string{"orders:write", "admin:limits"}}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("got principal %+v, want %+v", p, want)
+			}
+		})
+	}
+}
+
+func TestJWTScpClaim(t *testing.T) {
+	token := sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+		delete(c, "scope")
+		c["scp"] = []string{"orders:write"}
+	}))
+	p, err := authenticate(testJWT(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Scopes, []string{"orders:write"}) {
+		t.Errorf("got scopes %v, want [orders:write]", p.Scopes)
+	}
+}
+
+func TestJWTRejects(t *testing.T) {
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := sign(t, "RS256", "rsa", rsaKey, testClaims())
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+	}{
+		// Signatures.
+		{"alg none", func(t *testing.T) string { return sign(t, "none", "rsa", nil, testClaims()) }},
+		{"alg none without kid", func(t *testing.T) string { return sign(t, "none", "", nil, testClaims()) }},
+		{"HS256 with the RSA public key", func(t *testing.T) string {
+			return sign(t, "HS256", "rsa", rsaPublicDER, testClaims())
+		}},
+		{"HS256 with the RSA modulus", func(t *testing.T) string {
+			return sign(t, "HS256", "", rsaKey.PublicKey.N.Bytes(), testClaims())
+		}},
+		{"unknown algorithm", func(t *testing.T) string {
+			return encodeSegment(map[string]string{"alg": "XS256", "kid": "rsa"}) + "." + parts[1] + "." + parts[2]
+		}},
+		{"unknown kid", func(t *testing.T) string { return sign(t, "RS256", "rotated", rsaKey, testClaims()) }},
+		{"kid of another key", func(t *testing.T) string { return sign(t, "RS256", "ec", rsaKey, testClaims()) }},
+		{"algorithm the key is not for", func(t *testing.T) string {
+			return sign(t, "HS512", "hmac", hmacSecret, testClaims())
+		}},
+		{"ES384 with a P-256 key", func(t *testing.T) string { return sign(t, "ES384", "", ecKey256, testClaims()) }},
+		{"another signer", func(t *testing.T) string { return sign(t, "RS256", "rsa", mustRSAKey(), testClaims()) }},
+		{"tampered claims", func(t *testing.T) string {
+			forged := encodeSegment(testClaims(func(c map[string]any) { c["sub"] = "admin" }))
+			return parts[0] + "." + forged + "." + parts[2]
+		}},
+
+		// Claims.
+		{"expired", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+				c["exp"] = testNow.Add(-leeway - time.Second).Unix()
+			}))
+		}},
+		{"not valid yet", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) {
+				c["nbf"] = testNow.Add(leeway + time.Second).Unix()
+			}))
+		}},
+		{"no expiration", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { delete(c, "exp") }))
+		}},
+		{"no subject", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { delete(c, "sub") }))
+		}},
+		{"issuer mismatch", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { c["iss"] = "https://evil.example.com" }))
+		}},
+		{"no issuer", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { delete(c, "iss") }))
+		}},
+		{"audience mismatch", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { c["aud"] = []string{"billing"} }))
+		}},
+		{"no audience", func(t *testing.T) string {
+			return sign(t, "RS256", "rsa", rsaKey, testClaims(func(c map[string]any) { delete(c, "aud") }))
+		}},
+
+		// Encoding.
+		{"two segments", func(t *testing.T) string { return parts[0] + "." + parts[1] }},
+		{"four segments", func(t *testing.T) string { return valid + "." + parts[2] }},
+		{"empty", func(t *testing.T) string { return "" }},
+		{"header not base64", func(t *testing.T) string { return "*." + parts[1] + "." + parts[2] }},
+		{"header not JSON", func(t *testing.T) string {
+			return base64.RawURLEncoding.EncodeToString([]byte("alg")) + "." + parts[1] + "." + parts[2]
+		}},
+		{"signature not base64", func(t *testing.T) string { return parts[0] + "." + parts[1] + ".!" }},
+		{"claims not base64", func(t *testing.T) string { return resign("~~~~") }},
+		{"claims not JSON", func(t *testing.T) string {
+			return resign(base64.RawURLEncoding.EncodeToString([]byte("{")))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := authenticate(testJWT(), tt.token(t))
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("got principal %+v and error %v, want %v", p, err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+// resign returns a token of the claims segment as is, validly signed with
+// the HMAC key, so only the claims are at fault.
+func resign(claims string) string {
+	header := encodeSegment(map[string]string{"alg": "HS256", "kid": "hmac"})
+	mac := hmac.New(crypto.SHA256.New, hmacSecret)
+	mac.Write([]byte(header + "." + claims))
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTNoCredentials(t *testing.T) {
+	for _, header := range []string{"", "Basic dXNlcjpwYXNz", "Bearer"} {
+		r := httptest.NewRequest("POST", "/v1/orders", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if _, err := testJWT().Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authorization %q: got error %v, want %v", header, err, ErrNoCredentials)
+		}
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	rsaJWK := map[string]string{
+		"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig",
+		"n": enc(rsaKey.PublicKey.N.Bytes()),
+		"e": enc(big.NewInt(int64(rsaKey.PublicKey.E)).Bytes()),
+	}
+	ecJWK := map[string]string{
+		"kty": "EC", "kid": "ec", "crv": "P-256",
+		"x": enc(ecKey256.PublicKey.X.FillBytes(make([]byte, 32))),
+		"y": enc(ecKey256.PublicKey.Y.FillBytes(make([]byte, 32))),
+	}
+	octJWK := map[string]string{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": enc(hmacSecret)}
+	encJWK := map[string]string{"kty": "oct", "kid": "enc", "use": "enc", "k": enc(hmacSecret)}
+
+	data, _ := json.Marshal(map[string]any{"keys": []any{rsaJWK, ecJWK, octJWK, encJWK}})
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("got %d keys, want 3, the encryption key skipped", len(keys))
+	}
+	if pub, ok := keys[0].Public.(*rsa.PublicKey); !ok || !pub.Equal(&rsaKey.PublicKey) || keys[0].Algorithm != "RS256" {
+		t.Errorf("got RSA key %+v", keys[0])
+	}
+	if pub, ok := keys[1].Public.(*ecdsa.PublicKey); !ok || !pub.Equal(&ecKey256.PublicKey) {
+		t.Errorf("got EC key %+v", keys[1])
+	}
+	if secret, ok := keys[2].Public.([]byte); !ok || string(secret) != string(hmacSecret) {
+		t.Errorf("got oct key %+v", keys[2])
+	}
+
+	// The parsed keys verify tokens.
+	j := testJWT()
+	j.Keys = keys
+	if _, err := authenticate(j, sign(t, "ES256", "ec", ecKey256, testClaims())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseJWKSRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		jwks string
+	}{
+		{"not JSON", `{"keys": [`},
+		{"no keys", `{"keys": []}`},
+		{"only encryption keys", `{"keys": [{"kty": "oct", "use": "enc", "k": "c2VjcmV0"}]}`},
+		{"unsupported key type", `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AAAA"}]}`},
+		{"unsupported curve", `{"keys": [{"kty": "EC", "crv": "secp256k1", "x": "AAAA", "y": "AAAA"}]}`},
+		{"point not on curve", `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`},
+		{"modulus not base64", `{"keys": [{"kty": "RSA", "n": "*", "e": "AQAB"}]}`},
+		{"exponent too large", `{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQIDBAU"}]}`},
+		{"secret not base64", `{"keys": [{"kty": "oct", "k": "*"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if keys, err := ParseJWKS([]byte(tt.jwks)); err == nil {
+				t.Errorf("got keys %+v, want an error", keys)
+			}
+		})
+	}
+}
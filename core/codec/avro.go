@@ -0,0 +1,121 @@
+package codec
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Avro encodes events as Avro binary. The JSON of an event must match the
+// Avro schema field for field; optional fields are given defaults in the
+// schema, so events leaving them out still encode. Unions are read and
+// written as plain JSON values, i.e. null or the object of a record.
+type Avro struct {
+	registry Registry
+	// ids holds the schema each event type is written with.
+	ids map[string]int
+
+	mu     sync.Mutex
+	codecs map[int]*goavro.Codec
+}
+
+// NewAvro returns an Avro codec for eventTypes, writing each with the latest
+// schema of its subject in registry.
+func NewAvro(registry Registry, eventTypes ...string) (*Avro, error) {
+	a := &Avro{
+		registry: registry,
+		ids:      make(map[string]int, len(eventTypes)),
+		codecs:   make(map[int]*goavro.Codec),
+	}
+	for _, eventType := range eventTypes {
+		s, err := registry.LatestSchema(Subject(eventType, AvroFormat))
+		if err != nil {
+			return nil, fmt.Errorf("avro codec: %w", err)
+		}
+		if _, err := a.codec(s.ID); err != nil {
+			return nil, err
+		}
+		a.ids[eventType] = s.ID
+	}
+	return a, nil
+}
+
+func (a *Avro) Format() string      { return AvroFormat }
+func (a *Avro) ContentType() string { return AvroContentType }
+
+func (a *Avro) Supports(eventType string) bool {
+	_, ok := a.ids[eventType]
+	return ok
+}
+
+func (a *Avro) Encode(eventType string, data []byte) ([]byte, error) {
+	id, ok := a.ids[eventType]
+	if !ok {
+		return nil, fmt.Errorf("avro encode %s: %w", eventType, ErrUnsupported)
+	}
+	c, err := a.codec(id)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := c.NativeFromTextual(data)
+	if err != nil {
+		return nil, fmt.Errorf("avro encode %s: %w", eventType, err)
+	}
+	payload, err := c.BinaryFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("avro encode %s: %w", eventType, err)
+	}
+	return frame(id, payload), nil
+}
+
+// Decode reads data with the schema it was written with, which may be older
+// or newer than the one this service writes.
+func (a *Avro) Decode(eventType string, data []byte) ([]byte, error) {
+	id, payload, err := unframe(data)
+	if err != nil {
+		return nil, fmt.Errorf("avro decode %s: %w", eventType, err)
+	}
+	c, err := a.codec(id)
+	if err != nil {
+		return nil, err
+	}
+	native, rest, err := c.NativeFromBinary(payload)
+	if err != nil {
+		return nil, fmt.Errorf("avro decode %s: %w", eventType, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("avro decode %s: %d trailing bytes", eventType, len(rest))
+	}
+	out, err := c.TextualFromNative(nil, native)
+	if err != nil {
+		return nil, fmt.Errorf("avro decode %s: %w", eventType, err)
+	}
+	out, err = omitNull(out)
+	if err != nil {
+		return nil, fmt.Errorf("avro decode %s: %w", eventType, err)
+	}
+	return out, nil
+}
+
+// codec returns the compiled schema id, compiling it on first use.
+func (a *Avro) codec(id int) (*goavro.Codec, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.codecs[id]; ok {
+		return c, nil
+	}
+	s, err := a.registry.SchemaByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("avro codec: %w", err)
+	}
+	if s.Type != AvroSchema {
+		return nil, fmt.Errorf("avro codec: schema %d is %s", id, s.Type)
+	}
+	c, err := goavro.NewCodecForStandardJSONFull(s.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("avro codec: schema %d: %w", id, err)
+	}
+	a.codecs[id] = c
+	return c, nil
+}
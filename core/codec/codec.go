@@ -0,0 +1,138 @@
+// Package codec converts events between their canonical JSON encoding and
+// the wire formats published to Kafka.
+//
+// Events are built, validated and upcast as JSON. A codec only changes how
+// the bytes travel: the producer encodes the JSON after validating it and
+// names the codec in the content-type header, the consumer decodes back to
+// JSON before anything else looks at the event. Avro and Protobuf messages
+// are framed like the Confluent serializers frame them, so their schema is
+// found in a schema registry by the ID in front of the payload.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+// Formats selectable in the service configuration.
+const (
+	JSONFormat     = "json"
+	AvroFormat     = "avro"
+	ProtobufFormat = "protobuf"
+)
+
+// Content types written to the content-type header.
+const (
+	JSONContentType     = "application/json"
+	AvroContentType     = "application/vnd.apache.avro+binary"
+	ProtobufContentType = "application/x-protobuf"
+)
+
+// ErrUnsupported is returned for event types a codec has no schema for.
+var ErrUnsupported = errors.New("event type not supported by codec")
+
+// Codec converts JSON encoded events to a wire format and back.
+type Codec interface {
+	// Format is the name of the codec in the configuration.
+	Format() string
+	// ContentType identifies the wire format in message headers.
+	ContentType() string
+	// Supports reports whether events of eventType can be encoded.
+	Supports(eventType string) bool
+	// Encode converts the JSON encoded eventType event data.
+	Encode(eventType string, data []byte) ([]byte, error)
+	// Decode converts eventType event data back to JSON.
+	Decode(eventType string, data []byte) ([]byte, error)
+}
+
+// JSON passes events through unchanged. It is the format of messages
+// published without a content-type header.
+type JSON struct{}
+
+func (JSON) Format() string                 { return JSONFormat }
+func (JSON) ContentType() string            { return JSONContentType }
+func (JSON) Supports(eventType string) bool { return true }
+
+func (JSON) Encode(eventType string, data []byte) ([]byte, error) { return data, nil }
+func (JSON) Decode(eventType string, data []byte) ([]byte, error) { return data, nil }
+
+// Set holds the codecs a service reads and writes.
+type Set struct {
+	codecs []Codec
+}
+
+// NewSet returns a set of codecs. JSON is always part of it.
+func NewSet(codecs ...Codec) *Set {
+	return &Set{codecs: append([]Codec{JSON{}}, codecs...)}
+}
+
+// ByFormat returns the codec with the given format name.
+func (s *Set) ByFormat(format string) (Codec, error) {
+	for _, c := range s.codecs {
+		if c.Format() == format {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", format)
+}
+
+// ByContentType returns the codec of a message's content-type header,
+// ignoring parameters such as the charset. Messages without one are JSON.
+func (s *Set) ByContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON{}, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	for _, c := range s.codecs {
+		if c.ContentType() == mediaType {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported content type %q", contentType)
+}
+
+// ValidFormat reports whether format names one of the codecs of this
+// package.
+func ValidFormat(format string) bool {
+	switch format {
+	case JSONFormat, AvroFormat, ProtobufFormat:
+		return true
+	}
+	return false
+}
+
+// omitNull removes the null fields of the JSON object data. Codecs write
+// unset optional fields as null, the event JSON leaves them out.
+func omitNull(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var doc map[string]any
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	omitNullFields(doc)
+	return json.Marshal(doc)
+}
+
+func omitNullFields(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if field == nil {
+				delete(v, k)
+				continue
+			}
+			omitNullFields(field)
+		}
+	case []any:
+		for _, e := range v {
+			omitNullFields(e)
+		}
+	}
+}
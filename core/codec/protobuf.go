@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Protobuf encodes events as Protobuf messages. The schemas are .proto
+// files compiled when first used, so no generated code is needed; each
+// event type is the top level message of the same name, and its fields
+// map to the event JSON by their JSON names.
+type Protobuf struct {
+	registry Registry
+	// ids holds the schema each event type is written with.
+	ids map[string]int
+
+	mu    sync.Mutex
+	files map[int]protoreflect.FileDescriptor
+}
+
+// NewProtobuf returns a Protobuf codec for eventTypes, writing each with the
+// latest schema of its subject in registry.
+func NewProtobuf(registry Registry, eventTypes ...string) (*Protobuf, error) {
+	p := &Protobuf{
+		registry: registry,
+		ids:      make(map[string]int, len(eventTypes)),
+		files:    make(map[int]protoreflect.FileDescriptor),
+	}
+	for _, eventType := range eventTypes {
+		s, err := registry.LatestSchema(Subject(eventType, ProtobufFormat))
+		if err != nil {
+			return nil, fmt.Errorf("protobuf codec: %w", err)
+		}
+		fd, err := p.file(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		if fd.Messages().ByName(protoreflect.Name(eventType)) == nil {
+			return nil, fmt.Errorf("protobuf codec: schema %d has no message %s", s.ID, eventType)
+		}
+		p.ids[eventType] = s.ID
+	}
+	return p, nil
+}
+
+func (p *Protobuf) Format() string      { return ProtobufFormat }
+func (p *Protobuf) ContentType() string { return ProtobufContentType }
+
+func (p *Protobuf) Supports(eventType string) bool {
+	_, ok := p.ids[eventType]
+	return ok
+}
+
+func (p *Protobuf) Encode(eventType string, data []byte) ([]byte, error) {
+	id, ok := p.ids[eventType]
+	if !ok {
+		return nil, fmt.Errorf("protobuf encode %s: %w", eventType, ErrUnsupported)
+	}
+	fd, err := p.file(id)
+	if err != nil {
+		return nil, err
+	}
+	md := fd.Messages().ByName(protoreflect.Name(eventType))
+	msg := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("protobuf encode %s: %w", eventType, err)
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf encode %s: %w", eventType, err)
+	}
+	return frame(id, append(appendMessageIndexes(nil, []int{md.Index()}), payload...)), nil
+}
+
+// Decode reads data with the schema and message type it was written with.
+func (p *Protobuf) Decode(eventType string, data []byte) ([]byte, error) {
+	id, payload, err := unframe(data)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: %w", eventType, err)
+	}
+	indexes, payload, err := readMessageIndexes(payload)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: %w", eventType, err)
+	}
+	fd, err := p.file(id)
+	if err != nil {
+		return nil, err
+	}
+	md, err := messageAt(fd, indexes)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: schema %d: %w", eventType, id, err)
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: %w", eventType, err)
+	}
+	// Proto3 can't tell empty strings from missing ones, write them all so
+	// required JSON fields are present.
+	out, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: %w", eventType, err)
+	}
+	out, err = omitNull(out)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf decode %s: %w", eventType, err)
+	}
+	return out, nil
+}
+
+// messageAt follows message indexes from the top level messages of fd.
+func messageAt(fd protoreflect.FileDescriptor, indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := fd.Messages()
+	var md protoreflect.MessageDescriptor
+	for _, i := range indexes {
+		if i >= messages.Len() {
+			return nil, fmt.Errorf("no message at index %v", indexes)
+		}
+		md = messages.Get(i)
+		messages = md.Messages()
+	}
+	return md, nil
+}
+
+// file returns the compiled schema id, compiling it on first use.
+func (p *Protobuf) file(id int) (protoreflect.FileDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fd, ok := p.files[id]; ok {
+		return fd, nil
+	}
+	s, err := p.registry.SchemaByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: %w", err)
+	}
+	if s.Type != ProtobufSchema {
+		return nil, fmt.Errorf("protobuf codec: schema %d is %s", id, s.Type)
+	}
+
+	name := fmt.Sprintf("schema-%d.proto", id)
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{name: s.Definition}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), name)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf codec: schema %d: %w", id, err)
+	}
+	p.files[id] = files[0]
+	return files[0], nil
+}
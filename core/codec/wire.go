@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// magicByte starts every framed message, followed by the 4 byte big endian
+// schema ID and the payload.
+const magicByte = 0
+
+const frameHeaderSize = 5
+
+// frame prefixes payload with the wire header of schema id.
+func frame(id int, payload []byte) []byte {
+	out := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	out[0] = magicByte
+	binary.BigEndian.PutUint32(out[1:], uint32(id))
+	return append(out, payload...)
+}
+
+// unframe splits a framed message into its schema ID and payload.
+func unframe(data []byte) (int, []byte, error) {
+	if len(data) < frameHeaderSize {
+		return 0, nil, errors.New("message too short for the wire format")
+	}
+	if data[0] != magicByte {
+		return 0, nil, fmt.Errorf("unknown magic byte %d", data[0])
+	}
+	return int(binary.BigEndian.Uint32(data[1:frameHeaderSize])), data[frameHeaderSize:], nil
+}
+
+// appendMessageIndexes appends the path to a Protobuf message type within
+// its schema: the index of the top level message, then of each nested one.
+// The common case of the first top level message is a single zero.
+func appendMessageIndexes(b []byte, indexes []int) []byte {
+	if len(indexes) == 1 && indexes[0] == 0 {
+		return append(b, 0)
+	}
+	b = binary.AppendVarint(b, int64(len(indexes)))
+	for _, i := range indexes {
+		b = binary.AppendVarint(b, int64(i))
+	}
+	return b
+}
+
+// readMessageIndexes reads the indexes written by appendMessageIndexes and
+// returns the rest of data.
+func readMessageIndexes(data []byte) ([]int, []byte, error) {
+	n, size := binary.Varint(data)
+	if size <= 0 || n < 0 {
+		return nil, nil, errors.New("invalid message indexes")
+	}
+	data = data[size:]
+	if n == 0 {
+		return []int{0}, data, nil
+	}
+	indexes := make([]int, n)
+	for i := range indexes {
+		v, size := binary.Varint(data)
+		if size <= 0 || v < 0 {
+			return nil, nil, errors.New("invalid message indexes")
+		}
+		indexes[i] = int(v)
+		data = data[size:]
+	}
+	return indexes, data, nil
+}
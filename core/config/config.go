@@ -0,0 +1,292 @@
+// Package config loads the configuration shared by the services.
+//
+// Values are resolved with the following precedence, highest first:
+// command line flags, environment variables, the config file and the
+// defaults given by the service. Every flag has a matching environment
+// variable named after it, i.e -kafka-server is read from PPE_KAFKA_SERVER.
+// The config file is selected with -config or PPE_CONFIG and may be YAML or
+// TOML, chosen by its extension.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/snirkop89/ppe-ecommerce/core/auth"
+	"github.com/snirkop89/ppe-ecommerce/core/codec"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/ratelimit"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"github.com/snirkop89/ppe-ecommerce/schemas"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the environment variable of every flag.
+const envPrefix = "PPE_"
+
+type Config struct {
+	// Consumer is set by services that consume from Kafka. It enables the
+	// consumer group and idempotency store settings.
+	Consumer bool `yaml:"-" toml:"-"`
+	// API is set by services serving the public HTTP API. It enables the
+	// authentication, rate limit and database settings.
+	API bool `yaml:"-" toml:"-"`
+
+	Addr   string `yaml:"addr" toml:"addr"`
+	DBPath string `yaml:"dbPath" toml:"dbPath"`
+	// MaxBacklog is the producer backlog above which the service is not ready.
+	MaxBacklog int `yaml:"outboxMaxBacklog" toml:"outboxMaxBacklog"`
+	// ShutdownTimeout bounds draining requests and flushing the producer.
+	ShutdownTimeout time.Duration  `yaml:"shutdownTimeout" toml:"shutdownTimeout"`
+	Tracing         tracing.Config `yaml:"tracing" toml:"tracing"`
+	Kafka           Kafka          `yaml:"kafka" toml:"kafka"`
+	// DecodePolicy decides how consumed events with unknown fields are
+	// treated.
+	DecodePolicy schemas.Policy `yaml:"decodePolicy" toml:"decodePolicy"`
+	// SchemaRegistry is the directory of the file-backed schema registry
+	// used by the Avro and Protobuf codecs. Empty uses the built-in one.
+	SchemaRegistry string `yaml:"schemaRegistry" toml:"schemaRegistry"`
+	// Auth configures the credentials accepted by the API.
+	Auth auth.Config `yaml:"auth" toml:"auth"`
+	// RateLimit throttles the clients of the API.
+	RateLimit ratelimit.Config `yaml:"rateLimit" toml:"rateLimit"`
+}
+
+// Load resolves the configuration of service from args, the environment and
+// the config file, on top of defaults. Fields left empty in defaults get the
+// values common to all services.
+func Load(service string, defaults Config, args []string) (Config, error) {
+	cfg := withCommonDefaults(defaults)
+
+	path := configPath(args)
+	if path != "" {
+		if err := readFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	fs := flag.NewFlagSet(service, flag.ContinueOnError)
+	fs.String("config", path, "path to a YAML or TOML config file")
+	cfg.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	// Environment variables override the file, but not explicit flags.
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", EnvName(f.Name), err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
+	// Replicas of a service share its group, the transactional id is made
+	// unique per instance once the group is known.
+	if cfg.Consumer && cfg.Kafka.TransactionalID == "" {
+		cfg.Kafka.TransactionalID = instanceID(cfg.Kafka.GroupID)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// EnvName returns the environment variable read for flag name.
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func withCommonDefaults(cfg Config) Config {
+	setDefault(&cfg.Addr, ":8080")
+	setDefault(&cfg.MaxBacklog, 10000)
+	setDefault(&cfg.ShutdownTimeout, 10*time.Second)
+	setDefault(&cfg.Tracing.Exporter, tracing.ExporterNone)
+	setDefault(&cfg.Tracing.Endpoint, "localhost:4318")
+	setDefault(&cfg.Kafka.BootstrapServers, "localhost")
+	setDefault(&cfg.Kafka.Security.Protocol, "plaintext")
+	setDefault(&cfg.Kafka.ReplicationFactor, 1)
+	setDefault(&cfg.Kafka.Producer.Acks, "all")
+	setDefault(&cfg.Kafka.Producer.Linger, 5*time.Millisecond)
+	setDefault(&cfg.Kafka.Codec, codec.JSONFormat)
+	setDefault(&cfg.Kafka.Envelope, publisher.EnvelopeLegacy)
+	if cfg.API {
+		setDefault(&cfg.RateLimit.IPPerMinute, 60)
+		setDefault(&cfg.RateLimit.IPBurst, 20)
+		setDefault(&cfg.RateLimit.APIKeyPerMinute, 600)
+		setDefault(&cfg.RateLimit.APIKeyBurst, 100)
+		setDefault(&cfg.RateLimit.CustomerOrders, 5)
+		setDefault(&cfg.RateLimit.CustomerWindow, time.Hour)
+	}
+	if cfg.Consumer {
+		setDefault(&cfg.DBPath, filepath.Join(os.TempDir(), cfg.Kafka.GroupID))
+		setDefault(&cfg.DecodePolicy, schemas.Tolerant)
+	}
+	return cfg
+}
+
+// instanceID returns group suffixed with the host name, stable across
+// restarts of an instance and unique between instances. It is empty when the
+// host name is unknown.
+func instanceID(group string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return ""
+	}
+	return group + "-" + host
+}
+
+func setDefault[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// configPath finds the config file in args or the environment, before the
+// flags are parsed.
+func configPath(args []string) string {
+	for i, arg := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(EnvName("config"))
+}
+
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("read config: unsupported file extension %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("read config %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on, i.e 127.0.0.1:8000")
+	fs.IntVar(&cfg.MaxBacklog, "outbox-max-backlog", cfg.MaxBacklog, "undelivered messages above which the service reports not ready")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time allowed for in-flight work and producer flush on shutdown")
+
+	fs.StringVar(&cfg.SchemaRegistry, "schema-registry", cfg.SchemaRegistry, "directory of the file-backed schema registry, built-in when empty")
+
+	fs.StringVar(&cfg.Tracing.Exporter, "trace-exporter", cfg.Tracing.Exporter, "trace exporter: otlp, stdout or none")
+	fs.StringVar(&cfg.Tracing.Endpoint, "trace-endpoint", cfg.Tracing.Endpoint, "OTLP/HTTP collector address")
+	fs.StringVar(&cfg.Tracing.File, "trace-file", cfg.Tracing.File, "file to write spans to with the stdout exporter")
+
+	k := &cfg.Kafka
+	fs.StringVar(&k.BootstrapServers, "kafka-server", k.BootstrapServers, "kafka bootstrap servers, comma separated")
+	fs.StringVar(&k.Security.Protocol, "kafka-security-protocol", k.Security.Protocol, "plaintext, ssl, sasl_plaintext or sasl_ssl")
+	fs.StringVar(&k.Security.SASLMechanism, "kafka-sasl-mechanism", k.Security.SASLMechanism, "SASL mechanism, i.e PLAIN or SCRAM-SHA-512")
+	fs.StringVar(&k.Security.Username, "kafka-sasl-username", k.Security.Username, "SASL username")
+	fs.StringVar(&k.Security.Password, "kafka-sasl-password", k.Security.Password, "SASL password")
+	fs.StringVar(&k.Security.CAFile, "kafka-ssl-ca-location", k.Security.CAFile, "CA certificate file for verifying the broker")
+	fs.StringVar(&k.Producer.Acks, "kafka-acks", k.Producer.Acks, "producer acknowledgements: all, 1 or 0")
+	fs.DurationVar(&k.Producer.Linger, "kafka-linger", k.Producer.Linger, "time to wait for batching messages")
+	fs.IntVar(&k.Producer.BatchSize, "kafka-batch-size", k.Producer.BatchSize, "maximum messages per batch, 0 for default")
+	fs.StringVar(&k.TopicPrefix, "kafka-topic-prefix", k.TopicPrefix, "environment prefix of every topic, i.e staging")
+	fs.IntVar(&k.ReplicationFactor, "kafka-replication-factor", k.ReplicationFactor, "replication factor of topics created at startup")
+	fs.StringVar(&k.Codec, "kafka-codec", k.Codec, "encoding of published events: json, avro or protobuf")
+	fs.StringVar((*string)(&k.Envelope), "kafka-envelope", string(k.Envelope), "envelope of published events: legacy, cloudevents-binary or cloudevents-structured")
+	fs.Func("kafka-topic", "override a topic name as name=topic, comma separated or repeated", k.parseTopics)
+
+	if cfg.API {
+		a := &cfg.Auth
+		fs.StringVar(&a.APIKeysFile, "auth-api-keys", a.APIKeysFile, "YAML file of the API keys of business integrations")
+		fs.StringVar(&a.JWKSFile, "auth-jwks", a.JWKSFile, "JWKS file of the keys signing bearer tokens")
+		fs.StringVar(&a.Issuer, "auth-issuer", a.Issuer, "issuer bearer tokens must have, any when empty")
+		fs.StringVar(&a.Audience, "auth-audience", a.Audience, "audience bearer tokens must have, any when empty")
+		fs.BoolVar(&a.Disabled, "auth-disabled", a.Disabled, "accept unauthenticated requests, for local development only")
+
+		rl := &cfg.RateLimit
+		fs.IntVar(&rl.IPPerMinute, "rate-limit-ip", rl.IPPerMinute, "requests per minute of each client IP, 0 for no limit")
+		fs.IntVar(&rl.IPBurst, "rate-limit-ip-burst", rl.IPBurst, "requests a client IP can make at once")
+		fs.IntVar(&rl.APIKeyPerMinute, "rate-limit-api-key", rl.APIKeyPerMinute, "requests per minute of each API key, 0 for no limit")
+		fs.IntVar(&rl.APIKeyBurst, "rate-limit-api-key-burst", rl.APIKeyBurst, "requests an API key can make at once")
+		fs.IntVar(&rl.CustomerOrders, "rate-limit-customer-orders", rl.CustomerOrders, "orders of each customer email per window, 0 for no limit")
+		fs.DurationVar(&rl.CustomerWindow, "rate-limit-customer-window", rl.CustomerWindow, "window of the customer order limit")
+		fs.BoolVar(&rl.TrustProxy, "trust-proxy-headers", rl.TrustProxy, "read client IPs from X-Forwarded-For, behind a reverse proxy only")
+	}
+
+	if cfg.API || cfg.Consumer {
+		fs.StringVar(&cfg.DBPath, "db-path", cfg.DBPath, "directory to create database")
+	}
+	if !cfg.Consumer {
+		return
+	}
+	fs.StringVar((*string)(&cfg.DecodePolicy), "decode-policy", string(cfg.DecodePolicy), "consumed events with unknown fields are rejected when strict, ignored when tolerant")
+	fs.StringVar(&k.GroupID, "kafka-group-id", k.GroupID, "consumer group id")
+	fs.BoolVar(&k.Transactional, "kafka-transactional", k.Transactional, "publish events and commit offsets in a single kafka transaction")
+	fs.StringVar(&k.TransactionalID, "kafka-transactional-id", k.TransactionalID, "transactional id, unique per service instance, the group id and host name by default")
+}
+
+// Validate reports every invalid setting.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Addr != "", "addr is required")
+	check(cfg.MaxBacklog > 0, "outbox-max-backlog must be positive")
+	check(cfg.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	switch cfg.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		check(false, "trace-exporter must be one of otlp, stdout or none")
+	}
+
+	errs = append(errs, cfg.Kafka.validate(cfg.Consumer)...)
+	if cfg.API || cfg.Consumer {
+		check(cfg.DBPath != "", "db-path is required")
+	}
+	if cfg.API {
+		if err := cfg.Auth.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := cfg.RateLimit.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if cfg.Consumer {
+		if _, err := schemas.ParsePolicy(string(cfg.DecodePolicy)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/codec"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/topic"
+)
+
+// validTopic matches the characters Kafka allows in topic names.
+var validTopic = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Kafka holds the settings used to build every Kafka client of a service.
+type Kafka struct {
+	BootstrapServers string             `yaml:"bootstrapServers" toml:"bootstrapServers"`
+	GroupID          string             `yaml:"groupId" toml:"groupId"`
+	Security         Security           `yaml:"security" toml:"security"`
+	Producer         publisher.Settings `yaml:"producer" toml:"producer"`
+	Transactional    bool               `yaml:"transactional" toml:"transactional"`
+	// TransactionalID must be unique per instance of a service, replicas
+	// sharing one fence each other.
+	TransactionalID string `yaml:"transactionalId" toml:"transactionalId"`
+	// TopicPrefix separates the topics of environments sharing a cluster.
+	TopicPrefix string `yaml:"topicPrefix" toml:"topicPrefix"`
+	// Topics overrides individual topic names, see v1.TopicRegistry.
+	Topics map[string]string `yaml:"topics" toml:"topics"`
+	// ReplicationFactor is used for the topics created at startup.
+	ReplicationFactor int `yaml:"replicationFactor" toml:"replicationFactor"`
+	// Codec is the format published events are encoded in. Consumers read
+	// every format.
+	Codec string `yaml:"codec" toml:"codec"`
+	// Envelope is the legacy or a CloudEvents envelope of published events.
+	// Consumers read every envelope.
+	Envelope publisher.Envelope `yaml:"envelope" toml:"envelope"`
+}
+
+type Security struct {
+	// Protocol is plaintext, ssl, sasl_plaintext or sasl_ssl.
+	Protocol      string `yaml:"protocol" toml:"protocol"`
+	SASLMechanism string `yaml:"saslMechanism" toml:"saslMechanism"`
+	Username      string `yaml:"username" toml:"username"`
+	Password      string `yaml:"password" toml:"password"`
+	CAFile        string `yaml:"caFile" toml:"caFile"`
+}
+
+// TopicRegistry returns the registry resolving topic names for the
+// configured environment.
+func (k Kafka) TopicRegistry() v1.TopicRegistry {
+	return v1.TopicRegistry{Prefix: k.TopicPrefix, Overrides: k.Topics}
+}
+
+// TopicSpecs returns the specs of the named topics from v1.TopicSpecs, under
+// their cluster names.
+func (k Kafka) TopicSpecs(names ...string) []topic.Spec {
+	registry := k.TopicRegistry()
+	specs := make([]topic.Spec, 0, len(names))
+	for _, name := range names {
+		s := v1.TopicSpecs[name]
+		specs = append(specs, topic.Spec{
+			Name:              registry.Name(name),
+			Partitions:        s.Partitions,
+			ReplicationFactor: k.ReplicationFactor,
+			Retention:         s.Retention,
+			Compact:           s.Compact,
+		})
+	}
+	return specs
+}
+
+func (k *Kafka) parseTopics(s string) error {
+	if k.Topics == nil {
+		k.Topics = make(map[string]string)
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, topic, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" || topic == "" {
+			return fmt.Errorf("invalid topic override %q, want name=topic", pair)
+		}
+		k.Topics[name] = topic
+	}
+	return nil
+}
+
+// ClientConfig returns the connection and security settings shared by
+// producers, consumers and admin clients.
+func (k Kafka) ClientConfig() *kafka.ConfigMap {
+	cm := &kafka.ConfigMap{
+		"bootstrap.servers": k.BootstrapServers,
+		"security.protocol": k.Security.Protocol,
+	}
+	if k.Security.SASLMechanism != "" {
+		cm.SetKey("sasl.mechanism", k.Security.SASLMechanism)
+		cm.SetKey("sasl.username", k.Security.Username)
+		cm.SetKey("sasl.password", k.Security.Password)
+	}
+	if k.Security.CAFile != "" {
+		cm.SetKey("ssl.ca.location", k.Security.CAFile)
+	}
+	return cm
+}
+
+// ProducerConfig returns the client config with the producer settings.
+func (k Kafka) ProducerConfig() (*kafka.ConfigMap, error) {
+	cm := k.ClientConfig()
+	if err := k.Producer.Apply(cm); err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// ConsumerConfig returns the client config for the consumer group.
+func (k Kafka) ConsumerConfig() *kafka.ConfigMap {
+	cm := k.ClientConfig()
+	cm.SetKey("group.id", k.GroupID)
+	cm.SetKey("auto.offset.reset", "earliest")
+	if k.Transactional {
+		// Offsets are committed as part of the producer's transaction.
+		cm.SetKey("enable.auto.commit", false)
+	}
+	return cm
+}
+
+func (k Kafka) validate(consumer bool) []error {
+	var errs []error
+	if k.BootstrapServers == "" {
+		errs = append(errs, fmt.Errorf("kafka-server is required"))
+	}
+	switch k.Security.Protocol {
+	case "plaintext", "ssl", "sasl_plaintext", "sasl_ssl":
+	default:
+		errs = append(errs, fmt.Errorf("kafka-security-protocol %q is not supported", k.Security.Protocol))
+	}
+	if strings.HasPrefix(k.Security.Protocol, "sasl") && (k.Security.SASLMechanism == "" || k.Security.Username == "") {
+		errs = append(errs, fmt.Errorf("kafka-sasl-mechanism and kafka-sasl-username are required with %s", k.Security.Protocol))
+	}
+	if k.TopicPrefix != "" && !validTopic.MatchString(k.TopicPrefix) {
+		errs = append(errs, fmt.Errorf("kafka-topic-prefix %q may only contain letters, digits, '.', '_' and '-'", k.TopicPrefix))
+	}
+	for name, topic := range k.Topics {
+		if !validTopic.MatchString(topic) {
+			errs = append(errs, fmt.Errorf("kafka-topic %s=%s is not a valid topic name", name, topic))
+		}
+	}
+	if !codec.ValidFormat(k.Codec) {
+		errs = append(errs, fmt.Errorf("kafka-codec must be json, avro or protobuf"))
+	}
+	if _, err := publisher.ParseEnvelope(string(k.Envelope)); err != nil {
+		errs = append(errs, fmt.Errorf("kafka-envelope: %w", err))
+	}
+	if k.ReplicationFactor < 1 {
+		errs = append(errs, fmt.Errorf("kafka-replication-factor must be positive"))
+	}
+	switch k.Producer.Acks {
+	case "all", "1", "0":
+	default:
+		errs = append(errs, fmt.Errorf("kafka-acks must be all, 1 or 0"))
+	}
+	if consumer {
+		if k.GroupID == "" {
+			errs = append(errs, fmt.Errorf("kafka-group-id is required"))
+		}
+		if k.Transactional && k.TransactionalID == "" {
+			errs = append(errs, fmt.Errorf("kafka-transactional-id is required in transactional mode, unique per instance"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,241 @@
+package consumer
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+)
+
+// maxRebalances is the number of rebalances kept in the history.
+const maxRebalances = 20
+
+// queryTimeout bounds the broker queries made for a lag report.
+const queryTimeout = 5 * time.Second
+
+type partition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// Rebalance is an entry of the rebalance history.
+type Rebalance struct {
+	Time       time.Time   `json:"time"`
+	Type       string      `json:"type"`
+	Partitions []partition `json:"partitions"`
+}
+
+// PartitionLag reports the progress of the consumer on one partition.
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Committed is the group's committed offset, -1 if nothing was committed.
+	Committed int64 `json:"committed"`
+	// Position is the offset of the next message the consumer will read.
+	Position      int64      `json:"position"`
+	HighWatermark int64      `json:"highWatermark"`
+	Lag           int64      `json:"lag"`
+	LastMessage   *time.Time `json:"lastMessage,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+type Report struct {
+	Partitions []PartitionLag `json:"partitions"`
+	Rebalances []Rebalance    `json:"rebalances"`
+}
+
+// Tracker follows the partition assignment and progress of a consumer, and
+// reports its lag over HTTP and as metrics.
+type Tracker struct {
+	consumer *kafka.Consumer
+
+	mu sync.Mutex
+	// joined is set once the group assigned partitions, none included.
+	joined      bool
+	assigned    map[partition]bool
+	lastMessage map[partition]time.Time
+	rebalances  []Rebalance
+}
+
+func NewTracker(c *kafka.Consumer) *Tracker {
+	return &Tracker{
+		consumer:    c,
+		assigned:    make(map[partition]bool),
+		lastMessage: make(map[partition]time.Time),
+	}
+}
+
+// RebalanceCallback records assignment changes. Pass it to
+// kafka.Consumer.Subscribe; the assignment itself is left to the client.
+func (t *Tracker) RebalanceCallback(c *kafka.Consumer, ev kafka.Event) error {
+	var (
+		typ   string
+		parts []kafka.TopicPartition
+	)
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		typ, parts = "assigned", e.Partitions
+	case kafka.RevokedPartitions:
+		typ, parts = "revoked", e.Partitions
+	default:
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if typ == "assigned" {
+		t.joined = true
+	}
+	rb := Rebalance{Time: time.Now(), Type: typ}
+	revoked := make(map[string][]int32)
+	for _, tp := range parts {
+		p := partition{Topic: *tp.Topic, Partition: tp.Partition}
+		rb.Partitions = append(rb.Partitions, p)
+		if typ == "assigned" {
+			t.assigned[p] = true
+			continue
+		}
+		delete(t.assigned, p)
+		delete(t.lastMessage, p)
+		revoked[p.Topic] = append(revoked[p.Topic], p.Partition)
+	}
+	t.rebalances = append(t.rebalances, rb)
+	if len(t.rebalances) > maxRebalances {
+		t.rebalances = t.rebalances[len(t.rebalances)-maxRebalances:]
+	}
+	metrics.ConsumerRebalanced(typ, len(t.assigned), revoked)
+	return nil
+}
+
+// Assigned returns the number of partitions assigned to the consumer.
+func (t *Tracker) Assigned() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.assigned)
+}
+
+// Joined reports whether the consumer joined its group, i.e. received an
+// assignment. The assignment may be empty when the group has more members
+// than partitions.
+func (t *Tracker) Joined() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.joined
+}
+
+// Observe records that msg was consumed.
+func (t *Tracker) Observe(msg *kafka.Message) {
+	now := time.Now()
+	p := partition{Topic: topic(msg), Partition: msg.TopicPartition.Partition}
+
+	t.mu.Lock()
+	t.lastMessage[p] = now
+	t.mu.Unlock()
+
+	metrics.ConsumerMessage(p.Topic, p.Partition, now)
+}
+
+// Report queries the committed offsets and high watermarks of the assigned
+// partitions and computes their lag.
+func (t *Tracker) Report() (Report, error) {
+	t.mu.Lock()
+	parts := make([]kafka.TopicPartition, 0, len(t.assigned))
+	for p := range t.assigned {
+		topic := p.Topic
+		parts = append(parts, kafka.TopicPartition{Topic: &topic, Partition: p.Partition})
+	}
+	report := Report{Rebalances: append([]Rebalance(nil), t.rebalances...)}
+	lastMessage := make(map[partition]time.Time, len(t.lastMessage))
+	for p, ts := range t.lastMessage {
+		lastMessage[p] = ts
+	}
+	t.mu.Unlock()
+
+	if len(parts) == 0 {
+		return report, nil
+	}
+
+	committed, err := t.consumer.Committed(parts, int(queryTimeout.Milliseconds()))
+	if err != nil {
+		return report, err
+	}
+	positions, err := t.consumer.Position(parts)
+	if err != nil {
+		return report, err
+	}
+
+	for i, c := range committed {
+		pl := PartitionLag{
+			Topic:     *c.Topic,
+			Partition: c.Partition,
+			Committed: offsetValue(c.Offset),
+			Position:  offsetValue(positions[i].Offset),
+		}
+		if ts, ok := lastMessage[partition{Topic: pl.Topic, Partition: pl.Partition}]; ok {
+			pl.LastMessage = &ts
+		}
+
+		low, high, err := t.consumer.QueryWatermarkOffsets(pl.Topic, pl.Partition, int(queryTimeout.Milliseconds()))
+		if err != nil {
+			pl.Error = err.Error()
+			report.Partitions = append(report.Partitions, pl)
+			continue
+		}
+		pl.HighWatermark = high
+		// Without a committed offset the whole retained log is pending.
+		from := pl.Committed
+		if from < 0 {
+			from = low
+		}
+		pl.Lag = max(high-from, 0)
+		metrics.SetConsumerLag(pl.Topic, pl.Partition, pl.Lag)
+		report.Partitions = append(report.Partitions, pl)
+	}
+
+	sort.Slice(report.Partitions, func(i, j int) bool {
+		a, b := report.Partitions[i], report.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return report, nil
+}
+
+func offsetValue(o kafka.Offset) int64 {
+	if o < 0 {
+		return -1
+	}
+	return int64(o)
+}
+
+// Run refreshes the lag metrics every interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// Errors are transient and reported by the admin endpoint.
+			_, _ = t.Report()
+		}
+	}
+}
+
+// Handler serves the lag report as JSON.
+func (t *Tracker) Handler(w http.ResponseWriter, r *http.Request) {
+	report, err := t.Report()
+	if err != nil {
+		httpio.ServiceUnavailableResponse(w, r, err.Error())
+		return
+	}
+	_ = httpio.WriteJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,115 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/snirkop89/ppe-ecommerce/core/logger"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+)
+
+// HandlerFunc handles a single consumed message.
+type HandlerFunc func(ctx context.Context, msg *kafka.Message) error
+
+// Router dispatches consumed messages to handlers by their event-type header,
+// so one topic can carry several event types.
+type Router struct {
+	handlers   map[string]HandlerFunc
+	deadLetter HandlerFunc
+	topicTypes func(topic string) (string, bool)
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers h for messages of eventType.
+func (r *Router) Handle(eventType string, h HandlerFunc) {
+	r.handlers[eventType] = h
+}
+
+// DeadLetter registers h for the messages no handler can take: those that
+// fail to unwrap and those of event types without a handler. h receives the
+// message as consumed, before unwrapping.
+func (r *Router) DeadLetter(h HandlerFunc) {
+	r.deadLetter = h
+}
+
+// TopicTypes sets how messages without an event-type header are typed: by
+// the event type f returns for their topic. Messages published before the
+// header existed are still on the topics, each carrying a single type.
+func (r *Router) TopicTypes(f func(topic string) (string, bool)) {
+	r.topicTypes = f
+}
+
+// Dispatch calls the handler registered for the message's event type. The
+// event and correlation IDs from the message headers are attached to the
+// handler's context for logging, and the handler runs in a consumer span
+// continuing the trace of the producer. CloudEvents are unwrapped first, so
+// handlers see every message in the legacy envelope.
+func (r *Router) Dispatch(ctx context.Context, msg *kafka.Message) error {
+	// Unwrapping edits the headers in place.
+	consumed := *msg
+	consumed.Headers = slices.Clone(msg.Headers)
+	unwrapErr := publisher.UnwrapCloudEvent(msg)
+	eventType := r.eventType(msg)
+	ctx = logger.WithAttrs(ctx,
+		"event_type", eventType,
+		"event_id", publisher.HeaderValue(msg, publisher.HeaderEventID),
+		"correlation_id", publisher.HeaderValue(msg, publisher.HeaderCorrelationID),
+	)
+	ctx, span := tracing.StartConsumer(ctx, msg)
+	defer span.End()
+
+	start := time.Now()
+	err := unwrapErr
+	if err == nil {
+		err = r.dispatch(ctx, eventType, msg)
+	}
+	if (unwrapErr != nil || errors.Is(err, errNoHandler)) && r.deadLetter != nil {
+		if dlErr := r.deadLetter(ctx, &consumed); dlErr != nil {
+			err = errors.Join(err, fmt.Errorf("dead letter: %w", dlErr))
+		}
+	}
+	metrics.EventConsumed(topic(msg), eventType, time.Since(start), err)
+	tracing.RecordError(span, err)
+	return err
+}
+
+var errNoHandler = errors.New("no handler")
+
+func (r *Router) dispatch(ctx context.Context, eventType string, msg *kafka.Message) error {
+	h, ok := r.handlers[eventType]
+	if !ok {
+		return fmt.Errorf("%w for event type %q", errNoHandler, eventType)
+	}
+	return h(ctx, msg)
+}
+
+// eventType returns the message's event-type header, or the type of its
+// topic when it has none.
+func (r *Router) eventType(msg *kafka.Message) string {
+	if t := EventType(msg); t != "" || r.topicTypes == nil {
+		return t
+	}
+	t, _ := r.topicTypes(topic(msg))
+	return t
+}
+
+// EventType returns the message's event-type header.
+func EventType(msg *kafka.Message) string {
+	return publisher.HeaderValue(msg, publisher.HeaderEventType)
+}
+
+func topic(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// metadataClient is implemented by both kafka.Producer and kafka.Consumer.
+type metadataClient interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+// KafkaBroker checks that broker metadata can be fetched through client.
+func KafkaBroker(client metadataClient) Check {
+	return func(ctx context.Context) error {
+		md, err := client.GetMetadata(nil, false, timeoutMs(ctx))
+		if err != nil {
+			return err
+		}
+		if len(md.Brokers) == 0 {
+			return errors.New("no brokers available")
+		}
+		return nil
+	}
+}
+
+// ConsumerJoined checks that the consumer joined its group. An instance
+// without partitions is ready too: it is a standby taking over partitions
+// of the members that leave.
+func ConsumerJoined(tracker interface{ Joined() bool }) Check {
+	return func(ctx context.Context) error {
+		if !tracker.Joined() {
+			return errors.New("consumer has not joined its group")
+		}
+		return nil
+	}
+}
+
+const probeKey = "_health_probe"
+
+// BadgerWritable checks that db accepts writes.
+func BadgerWritable(db *badger.DB) Check {
+	return func(ctx context.Context) error {
+		return db.Update(func(txn *badger.Txn) error {
+			e := badger.NewEntry([]byte(probeKey), []byte(time.Now().Format(time.RFC3339))).WithTTL(time.Minute)
+			return txn.SetEntry(e)
+		})
+	}
+}
+
+// OutboxBacklog checks that fewer than threshold messages are queued in the
+// producer awaiting delivery. A growing backlog means events are accepted
+// faster than Kafka takes them, or not at all.
+func OutboxBacklog(producer *kafka.Producer, threshold int) Check {
+	return func(ctx context.Context) error {
+		if n := producer.Len(); n >= threshold {
+			return fmt.Errorf("%d messages awaiting delivery, threshold is %d", n, threshold)
+		}
+		return nil
+	}
+}
+
+func timeoutMs(ctx context.Context) int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 5000
+	}
+	return max(int(time.Until(deadline).Milliseconds()), 1)
+}
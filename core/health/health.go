@@ -0,0 +1,103 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+)
+
+// Check reports whether a dependency is usable. It should return promptly
+// once ctx is done.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks registered by a service.
+type Checker struct {
+	timeout time.Duration
+	checks  []namedCheck
+}
+
+// New returns a checker that gives each check up to timeout to complete.
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check under name.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Result struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// Run executes all checks concurrently.
+func (c *Checker) Run(ctx context.Context) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	res := Result{Status: statusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := nc.check(ctx)
+			cr := CheckResult{Status: statusOK, Latency: time.Since(start).String()}
+			if err != nil {
+				cr.Status = statusUnavailable
+				cr.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[nc.name] = cr
+			if err != nil {
+				res.Status = statusUnavailable
+			}
+		}(nc)
+	}
+	wg.Wait()
+	return res
+}
+
+// ReadinessHandler reports the result of every check, responding with 503
+// if any of them failed.
+func (c *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	res := c.Run(r.Context())
+	code := http.StatusOK
+	if res.Status != statusOK {
+		code = http.StatusServiceUnavailable
+	}
+	_ = httpio.WriteJSON(w, code, res)
+}
+
+// LivenessHandler reports that the process is running. It deliberately
+// checks no dependencies, so an outage of Kafka doesn't restart every
+// service.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	httpio.HealthCheckHandler(w, r)
+}
@@ -2,36 +2,122 @@ package httpio
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
-func Decode(r io.Reader, data any) error {
-	dec := json.NewDecoder(r)
+// DefaultMaxBodyBytes bounds the request bodies read by Decode.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Decode reads the JSON request body of r into dst, see DecodeLimit.
+func Decode(w http.ResponseWriter, r *http.Request, dst any) error {
+	return DecodeLimit(w, r, dst, DefaultMaxBodyBytes)
+}
+
+// DecodeLimit reads the JSON request body of r, of at most limit bytes, into
+// dst. The body must hold a single JSON value without fields dst doesn't
+// have. Failures are returned as a *Problem explaining what is wrong with
+// the body, ready for DecodeErrorResponse.
+func DecodeLimit(w http.ResponseWriter, r *http.Request, dst any, limit int64) error {
+	if err := requireJSON(r); err != nil {
+		return err
+	}
+
+	body := http.MaxBytesReader(w, r.Body, limit)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
-	return dec.Decode(&data)
+	if err := dec.Decode(dst); err != nil {
+		return decodeProblem(err)
+	}
+	// Anything after the value, even another value, is rejected.
+	var extra json.RawMessage
+	if err := dec.Decode(&extra); !errors.Is(err, io.EOF) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return decodeProblem(err)
+		}
+		return NewProblem(http.StatusBadRequest, CodeMalformedBody,
+			fmt.Sprintf("body must contain a single JSON value, found more data at character %d", dec.InputOffset()))
+	}
+	return nil
 }
 
-func WriteJSON(w http.ResponseWriter, code int, v any) error {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	return json.NewEncoder(w).Encode(v)
+// requireJSON rejects requests with a body in another media type than JSON.
+// The problem matches ErrUnsupportedMediaType.
+func requireJSON(r *http.Request) error {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+	if contentType == "" {
+		contentType = "none"
+	}
+	p := NewProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+		fmt.Sprintf("content type must be application/json, got %s", contentType))
+	p.cause = ErrUnsupportedMediaType
+	return p
 }
 
-func BadRequestResponse(w http.ResponseWriter, msg string) error {
-	return WriteJSON(w, http.StatusBadRequest, map[string]string{
-		"error": msg,
-	})
+// decodeProblem explains a decoding error in terms of the request body.
+func decodeProblem(err error) *Problem {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	var detail string
+	switch {
+	case errors.As(err, &tooLarge):
+		return NewProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("body must not be larger than %d bytes", tooLarge.Limit))
+	case errors.As(err, &syntaxErr):
+		detail = fmt.Sprintf("body contains badly-formed JSON at character %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		detail = "body contains badly-formed JSON, it ends early"
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			detail = fmt.Sprintf("field %q must be %s, got %s at character %d", typeErr.Field, jsonKind(typeErr.Type.Kind()), typeErr.Value, typeErr.Offset)
+		} else {
+			detail = fmt.Sprintf("body must be %s, got %s at character %d", jsonKind(typeErr.Type.Kind()), typeErr.Value, typeErr.Offset)
+		}
+	case errors.Is(err, io.EOF):
+		detail = "body must not be empty"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder has no error type for unknown fields.
+		detail = "body contains unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	default:
+		detail = err.Error()
+	}
+	return NewProblem(http.StatusBadRequest, CodeMalformedBody, detail)
 }
 
-func InternalServerErrorResponse(w http.ResponseWriter, msg string) error {
-	return WriteJSON(w, http.StatusInternalServerError, map[string]string{
-		"error": msg,
-	})
+// jsonKind names the JSON type decoded into a Go kind.
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a " + kind.String()
 }
 
-func FailedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) error {
-	return WriteJSON(w, http.StatusUnprocessableEntity, errors)
+func WriteJSON(w http.ResponseWriter, code int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(v)
 }
 
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
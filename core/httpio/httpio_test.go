@@ -0,0 +1,113 @@
+package httpio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testOrder struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+}
+
+func TestDecodeLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// status and code are those of the problem, none when decoded.
+		status int
+		code   string
+	}{
+		{"valid", "application/json", `{"id": "a", "quantity": 2}`, 0, ""},
+		{"charset", "application/json; charset=utf-8", `{"id": "a"}`, 0, ""},
+		{"json suffix", "application/merge-patch+json", `{"id": "a"}`, 0, ""},
+		{"trailing whitespace", "application/json", "{\"id\": \"a\"}\n\t ", 0, ""},
+
+		// Media type.
+		{"no content type", "", `{"id": "a"}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", "id=a", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"text", "text/plain", `{"id": "a"}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"malformed content type", "application/json;;", `{"id": "a"}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+
+		// Size.
+		{"at the limit", "application/json", `{"id": "` + strings.Repeat("a", 54) + `"}`, 0, ""},
+		{"over the limit", "application/json", `{"id": "` + strings.Repeat("a", 55) + `"}`, http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+		{"over the limit after the value", "application/json", `{"id": "a"}` + strings.Repeat(" ", 60), http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+
+		// Contents.
+		{"unknown field", "application/json", `{"id": "a", "price": 3}`, http.StatusBadRequest, CodeMalformedBody},
+		{"trailing value", "application/json", `{"id": "a"}{"id": "b"}`, http.StatusBadRequest, CodeMalformedBody},
+		{"trailing garbage", "application/json", `{"id": "a"} x`, http.StatusBadRequest, CodeMalformedBody},
+		{"empty", "application/json", "", http.StatusBadRequest, CodeMalformedBody},
+		{"badly formed", "application/json", `{"id": "a",}`, http.StatusBadRequest, CodeMalformedBody},
+		{"ends early", "application/json", `{"id": "a"`, http.StatusBadRequest, CodeMalformedBody},
+		{"wrong type", "application/json", `{"quantity": "2"}`, http.StatusBadRequest, CodeMalformedBody},
+		{"not an object", "application/json", `[1, 2]`, http.StatusBadRequest, CodeMalformedBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/orders", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var dst testOrder
+			err := DecodeLimit(httptest.NewRecorder(), r, &dst, 64)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+
+			var p *Problem
+			if !errors.As(err, &p) {
+				t.Fatalf("got error %v, want a problem", err)
+			}
+			if p.Status != tt.status || p.Code != tt.code {
+				t.Errorf("got %d %s, want %d %s: %s", p.Status, p.Code, tt.status, tt.code, p.Detail)
+			}
+			if got := errors.Is(err, ErrUnsupportedMediaType); got != (tt.code == CodeUnsupportedMediaType) {
+				t.Errorf("got errors.Is(err, ErrUnsupportedMediaType) %t", got)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"problem", NewProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "too large"), http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+		{"max bytes error", &http.MaxBytesError{Limit: 64}, http.StatusRequestEntityTooLarge, CodeBodyTooLarge},
+		{"unsupported media type", ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{"other", errors.New("unexpected"), http.StatusBadRequest, CodeMalformedBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			DecodeErrorResponse(rec, httptest.NewRequest("POST", "/v1/orders", nil), tt.err)
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d", rec.Code, tt.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != ContentTypeProblem {
+				t.Errorf("got content type %s, want %s", ct, ContentTypeProblem)
+			}
+			var p Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.code || p.Status != tt.status || p.Instance != "/v1/orders" {
+				t.Errorf("got problem %+v, want status %d, code %s", p, tt.status, tt.code)
+			}
+		})
+	}
+}
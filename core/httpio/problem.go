@@ -0,0 +1,166 @@
+package httpio
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/snirkop89/ppe-ecommerce/core/validator"
+)
+
+// ContentTypeProblem is the media type of problem details, RFC 7807.
+const ContentTypeProblem = "application/problem+json"
+
+// Error codes of problems. They are part of the API: clients branch on
+// them, so existing codes must not change.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeMalformedBody        = "malformed_body"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeRateLimited          = "rate_limited"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeInternal             = "internal_error"
+	CodeUnavailable          = "service_unavailable"
+)
+
+// problemTypeBase prefixes the code of a problem to form its type URI.
+const problemTypeBase = "urn:ppe-ecommerce:problem:"
+
+// ErrUnsupportedMediaType is matched by the error of Decode when a request
+// body is not JSON. Handlers reading other media types may return it too.
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// Problem is the body of every error response, a problem details object as
+// defined by RFC 7807 with a few extension members.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed.
+	Instance string `json:"instance,omitempty"`
+	// Code identifies the problem, it is the last part of Type.
+	Code string `json:"code"`
+	// RequestID lets support find the request in the logs.
+	RequestID string `json:"requestId,omitempty"`
+	// Errors lists the invalid fields of a request failing validation.
+	Errors []validator.FieldError `json:"errors,omitempty"`
+	// cause is the sentinel error the problem matches with errors.Is.
+	cause error
+}
+
+// NewProblem returns a problem with the given status and code. The title is
+// the status text.
+func NewProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Code + ": " + p.Detail
+	}
+	return p.Code
+}
+
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// WriteProblem responds with p, completing it with the details of r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) error {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = middleware.GetReqID(r.Context())
+	}
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
+
+func BadRequestResponse(w http.ResponseWriter, r *http.Request, detail string) error {
+	return WriteProblem(w, r, NewProblem(http.StatusBadRequest, CodeBadRequest, detail))
+}
+
+// DecodeErrorResponse responds to a request whose body could not be
+// decoded, with the status matching the cause.
+func DecodeErrorResponse(w http.ResponseWriter, r *http.Request, err error) error {
+	var p *Problem
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &tooLarge):
+		p = NewProblem(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, err.Error())
+	case errors.Is(err, ErrUnsupportedMediaType):
+		p = NewProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, err.Error())
+	default:
+		p = NewProblem(http.StatusBadRequest, CodeMalformedBody, err.Error())
+	}
+	return WriteProblem(w, r, p)
+}
+
+// FailedValidationResponse responds with the field errors of a validator.
+func FailedValidationResponse(w http.ResponseWriter, r *http.Request, fields []validator.FieldError) error {
+	p := NewProblem(http.StatusUnprocessableEntity, CodeValidationFailed, "the request has invalid fields")
+	p.Errors = fields
+	return WriteProblem(w, r, p)
+}
+
+// UnauthorizedResponse rejects a request without valid credentials. The
+// challenge is sent in the WWW-Authenticate header, telling the client how
+// to authenticate.
+func UnauthorizedResponse(w http.ResponseWriter, r *http.Request, challenge, detail string) error {
+	if challenge != "" {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	return WriteProblem(w, r, NewProblem(http.StatusUnauthorized, CodeUnauthorized, detail))
+}
+
+// ForbiddenResponse rejects an authenticated request that is not allowed.
+func ForbiddenResponse(w http.ResponseWriter, r *http.Request, detail string) error {
+	return WriteProblem(w, r, NewProblem(http.StatusForbidden, CodeForbidden, detail))
+}
+
+// TooManyRequestsResponse rejects a request over a rate limit, telling the
+// client when to retry in the Retry-After header.
+func TooManyRequestsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, detail string) error {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return WriteProblem(w, r, NewProblem(http.StatusTooManyRequests, CodeRateLimited, detail))
+}
+
+// InternalServerErrorResponse reports a failure of the server. The detail
+// is shown to the client, so it must not leak internals.
+func InternalServerErrorResponse(w http.ResponseWriter, r *http.Request, detail string) error {
+	return WriteProblem(w, r, NewProblem(http.StatusInternalServerError, CodeInternal, detail))
+}
+
+func ServiceUnavailableResponse(w http.ResponseWriter, r *http.Request, detail string) error {
+	return WriteProblem(w, r, NewProblem(http.StatusServiceUnavailable, CodeUnavailable, detail))
+}
+
+// NotFoundHandler responds to requests for unknown routes.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	_ = WriteProblem(w, r, NewProblem(http.StatusNotFound, CodeNotFound, ""))
+}
+
+// MethodNotAllowedHandler responds to requests with a method the route
+// doesn't serve.
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	_ = WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, CodeMethodNotAllowed, ""))
+}
@@ -1,11 +1,15 @@
 package logger
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
 	"golang.org/x/term"
 )
 
@@ -19,10 +23,40 @@ func NewLogger(service string) *slog.Logger {
 		h = slog.NewJSONHandler(os.Stderr, nil)
 
 	}
-	l := slog.New(h.WithAttrs([]slog.Attr{{Key: "service", Value: slog.StringValue(service)}}))
+	h = contextHandler{h.WithAttrs([]slog.Attr{{Key: "service", Value: slog.StringValue(service)}})}
+	l := slog.New(h)
 	return l
 }
 
+type ctxKey struct{}
+
+// WithAttrs returns a copy of ctx carrying args as log attributes. They are
+// added to every record logged with the context by a logger from NewLogger.
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(ctxKey{}).([]any)
+	return context.WithValue(ctx, ctxKey{}, append(prev[:len(prev):len(prev)], args...))
+}
+
+// contextHandler adds the attributes stored by WithAttrs to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if args, ok := ctx.Value(ctxKey{}).([]any); ok {
+		r.Add(args...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
 func LoggingMiddleware(logger *slog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -32,10 +66,32 @@ func LoggingMiddleware(logger *slog.Logger) func(next http.Handler) http.Handler
 				slog.String("path", r.URL.Path),
 			}
 
+			// Attach the request ID to every log line written while handling
+			// the request.
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				r = r.WithContext(WithAttrs(r.Context(), "request_id", id))
+			}
+
 			start := time.Now()
-			next.ServeHTTP(w, r)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			elapsed := time.Since(start)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// Label by route pattern so metrics don't grow with every path.
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			metrics.ObserveHTTPRequest(r.Method, route, status, elapsed)
 
-			attributes = append(attributes, slog.String("latency", time.Since(start).String()))
+			attributes = append(attributes,
+				slog.Int("status", status),
+				slog.String("latency", elapsed.String()),
+			)
 
 			logger.WithGroup("http").LogAttrs(r.Context(), slog.LevelInfo, "Handled request", attributes...)
 		})
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ppe"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_rate_limited_total",
+		Help:      "HTTP requests rejected by a rate limit, by limit.",
+	}, []string{"limit"})
+
+	eventsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_produced_total",
+		Help:      "Events delivered to Kafka, by topic and result.",
+	}, []string{"topic", "result"})
+
+	eventsConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_consumed_total",
+		Help:      "Events consumed from Kafka, by topic, event type and result.",
+	}, []string{"topic", "event_type", "result"})
+
+	processingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_processing_duration_seconds",
+		Help:      "Time spent handling a consumed event, by topic and event type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic", "event_type"})
+
+	duplicatesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_duplicates_skipped_total",
+		Help:      "Consumed events skipped because they were already handled, by topic.",
+	}, []string{"topic"})
+
+	deadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letter_published_total",
+		Help:      "Events published to the dead letter queue, by source topic.",
+	}, []string{"topic"})
+
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag_messages",
+		Help:      "Messages between the committed offset and the high watermark, by topic and partition.",
+	}, []string{"topic", "partition"})
+
+	consumerLastMessage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_last_message_timestamp_seconds",
+		Help:      "Unix time of the last message consumed, by topic and partition.",
+	}, []string{"topic", "partition"})
+
+	consumerAssigned = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_assigned_partitions",
+		Help:      "Partitions currently assigned to the consumer.",
+	})
+
+	consumerRebalances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_rebalances_total",
+		Help:      "Consumer group rebalances, by type (assigned or revoked).",
+	}, []string{"type"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveHTTPRequest records a handled HTTP request. route should be the
+// route pattern rather than the raw path to keep cardinality bounded.
+func ObserveHTTPRequest(method, route string, code int, elapsed time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+}
+
+// RateLimited records a request rejected by the named rate limit.
+func RateLimited(limit string) {
+	rateLimited.WithLabelValues(limit).Inc()
+}
+
+// EventProduced records the delivery result of an event published to topic.
+func EventProduced(topic string, err error) {
+	eventsProduced.WithLabelValues(topic, result(err)).Inc()
+}
+
+// EventConsumed records the handling of an event consumed from topic.
+func EventConsumed(topic, eventType string, elapsed time.Duration, err error) {
+	eventsConsumed.WithLabelValues(topic, eventType, result(err)).Inc()
+	processingDuration.WithLabelValues(topic, eventType).Observe(elapsed.Seconds())
+}
+
+// DuplicateSkipped records an event from topic skipped by the idempotency
+// check.
+func DuplicateSkipped(topic string) {
+	duplicatesSkipped.WithLabelValues(topic).Inc()
+}
+
+// DeadLettered records an event from topic published to the dead letter queue.
+func DeadLettered(topic string) {
+	deadLettered.WithLabelValues(topic).Inc()
+}
+
+// SetConsumerLag records the lag of an assigned partition.
+func SetConsumerLag(topic string, partition int32, lag int64) {
+	consumerLag.WithLabelValues(topic, partitionLabel(partition)).Set(float64(lag))
+}
+
+// ConsumerMessage records the time a message was consumed from a partition.
+func ConsumerMessage(topic string, partition int32, t time.Time) {
+	consumerLastMessage.WithLabelValues(topic, partitionLabel(partition)).Set(float64(t.Unix()))
+}
+
+// ConsumerRebalanced records a rebalance and the resulting number of
+// assigned partitions. Revoked partitions stop being reported.
+func ConsumerRebalanced(eventType string, assigned int, revoked map[string][]int32) {
+	consumerRebalances.WithLabelValues(eventType).Inc()
+	consumerAssigned.Set(float64(assigned))
+	for topic, partitions := range revoked {
+		for _, p := range partitions {
+			consumerLag.DeleteLabelValues(topic, partitionLabel(p))
+			consumerLastMessage.DeleteLabelValues(topic, partitionLabel(p))
+		}
+	}
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.Itoa(int(partition))
+}
+
+// RegisterBadgerSize exposes the on-disk size of the idempotency store.
+func RegisterBadgerSize(db *badger.DB) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "badger_lsm_size_bytes",
+		Help:      "Size of the badger LSM tree.",
+	}, func() float64 {
+		lsm, _ := db.Size()
+		return float64(lsm)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "badger_vlog_size_bytes",
+		Help:      "Size of the badger value log.",
+	}, func() float64 {
+		_, vlog := db.Size()
+		return float64(vlog)
+	})
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,200 @@
+package publisher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Envelope selects how event metadata travels with a message.
+type Envelope string
+
+const (
+	// EnvelopeLegacy carries the metadata in the headers of headers.go only.
+	EnvelopeLegacy Envelope = "legacy"
+	// EnvelopeBinary adds the CloudEvents attributes as ce_ headers,
+	// leaving the value as it is.
+	EnvelopeBinary Envelope = "cloudevents-binary"
+	// EnvelopeStructured replaces the value with a CloudEvents JSON
+	// document holding the attributes and the event as its data.
+	EnvelopeStructured Envelope = "cloudevents-structured"
+)
+
+// ParseEnvelope parses the name of an envelope.
+func ParseEnvelope(s string) (Envelope, error) {
+	switch e := Envelope(s); e {
+	case EnvelopeLegacy, EnvelopeBinary, EnvelopeStructured:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown envelope %q, want legacy, cloudevents-binary or cloudevents-structured", s)
+}
+
+// CloudEvents attributes, mapped to Kafka headers in binary mode.
+const (
+	HeaderCESpecVersion = "ce_specversion"
+	HeaderCEID          = "ce_id"
+	HeaderCESource      = "ce_source"
+	HeaderCEType        = "ce_type"
+	HeaderCETime        = "ce_time"
+	HeaderCESubject     = "ce_subject"
+)
+
+const (
+	cloudEventsVersion     = "1.0"
+	contentTypeCloudEvents = "application/cloudevents+json"
+	// cloudEventsTypePrefix namespaces the event types of api/v1.
+	cloudEventsTypePrefix = "ppe.v1."
+)
+
+// CloudEvent is implemented by the events that can be published as
+// CloudEvents.
+type CloudEvent interface {
+	TypedEvent
+	EventTime() time.Time
+}
+
+// subjected is implemented by events about a single entity, i.e an order.
+type subjected interface {
+	EventSubject() string
+}
+
+// structuredEvent is the JSON format of a CloudEvent.
+type structuredEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// wrap puts the encoded msg into envelope. The legacy headers are kept in
+// every mode, so consumers not yet reading CloudEvents still route it.
+// Values that aren't events are left as they are.
+func wrap(envelope Envelope, service string, msg *kafka.Message, data any) error {
+	e, ok := data.(CloudEvent)
+	if !ok || envelope == "" || envelope == EnvelopeLegacy {
+		return nil
+	}
+	ce := structuredEvent{
+		SpecVersion:     cloudEventsVersion,
+		ID:              e.EventID(),
+		Source:          "/ppe-ecommerce/" + service,
+		Type:            cloudEventsTypePrefix + e.EventType(),
+		DataContentType: HeaderValue(msg, HeaderContentType),
+	}
+	if t := e.EventTime(); !t.IsZero() {
+		ce.Time = t.Format(time.RFC3339Nano)
+	}
+	if s, ok := data.(subjected); ok {
+		ce.Subject = s.EventSubject()
+	}
+
+	switch envelope {
+	case EnvelopeBinary:
+		msg.Headers = append(msg.Headers,
+			kafka.Header{Key: HeaderCESpecVersion, Value: []byte(ce.SpecVersion)},
+			kafka.Header{Key: HeaderCEID, Value: []byte(ce.ID)},
+			kafka.Header{Key: HeaderCESource, Value: []byte(ce.Source)},
+			kafka.Header{Key: HeaderCEType, Value: []byte(ce.Type)},
+		)
+		if ce.Time != "" {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: HeaderCETime, Value: []byte(ce.Time)})
+		}
+		if ce.Subject != "" {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: HeaderCESubject, Value: []byte(ce.Subject)})
+		}
+		return nil
+	case EnvelopeStructured:
+		if isJSON(ce.DataContentType) {
+			ce.Data = msg.Value
+		} else {
+			ce.DataBase64 = msg.Value
+		}
+		value, err := json.Marshal(ce)
+		if err != nil {
+			return err
+		}
+		msg.Value = value
+		setHeader(msg, HeaderContentType, contentTypeCloudEvents)
+		return nil
+	}
+	return fmt.Errorf("unknown envelope %q", envelope)
+}
+
+// UnwrapCloudEvent turns a CloudEvents message into the legacy envelope in
+// place: a structured event is replaced by its data, and the legacy
+// headers missing from messages of other producers are filled in from the
+// CloudEvents attributes. Other messages are left as they are.
+func UnwrapCloudEvent(msg *kafka.Message) error {
+	ce := structuredEvent{
+		ID:   HeaderValue(msg, HeaderCEID),
+		Type: HeaderValue(msg, HeaderCEType),
+	}
+	if isStructured(HeaderValue(msg, HeaderContentType)) {
+		if err := json.Unmarshal(msg.Value, &ce); err != nil {
+			return fmt.Errorf("invalid structured cloud event: %w", err)
+		}
+		if ce.SpecVersion != cloudEventsVersion {
+			return fmt.Errorf("unsupported cloud events version %q", ce.SpecVersion)
+		}
+		if ce.Data != nil && ce.DataBase64 != nil {
+			return errors.New("invalid structured cloud event: both data and data_base64 set")
+		}
+		msg.Value = ce.DataBase64
+		if ce.Data != nil {
+			msg.Value = ce.Data
+		}
+		setHeader(msg, HeaderContentType, ce.DataContentType)
+	}
+
+	if ce.Type != "" && HeaderValue(msg, HeaderEventType) == "" {
+		setHeader(msg, HeaderEventType, strings.TrimPrefix(ce.Type, cloudEventsTypePrefix))
+	}
+	if ce.ID != "" && HeaderValue(msg, HeaderEventID) == "" {
+		setHeader(msg, HeaderEventID, ce.ID)
+	}
+	return nil
+}
+
+// setHeader replaces the value of header key, or adds it. An empty value
+// removes the header.
+func setHeader(msg *kafka.Message, key, value string) {
+	headers := msg.Headers[:0]
+	for _, h := range msg.Headers {
+		if h.Key != key {
+			headers = append(headers, h)
+		}
+	}
+	if value != "" {
+		headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+	msg.Headers = headers
+}
+
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
+
+func isStructured(contentType string) bool {
+	return mediaType(contentType) == contentTypeCloudEvents
+}
+
+// isJSON reports whether data of contentType is inlined in structured
+// events. Data without a content type is JSON.
+func isJSON(contentType string) bool {
+	t := mediaType(contentType)
+	return t == "" || t == contentTypeJSON || strings.HasSuffix(t, "+json")
+}
@@ -0,0 +1,60 @@
+package publisher
+
+import "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+// Kafka header names set on every published message.
+const (
+	HeaderEventType     = "event-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderEventID       = "event-id"
+	HeaderCorrelationID = "correlation-id"
+	HeaderProducer      = "producer"
+	HeaderContentType   = "content-type"
+)
+
+const contentTypeJSON = "application/json"
+
+// TypedEvent is implemented by the api/v1 events. Their metadata is copied to
+// the message headers so consumers can route on the event type and tools can
+// inspect traffic without parsing bodies.
+type TypedEvent interface {
+	EventType() string
+	SchemaVersion() string
+	EventID() string
+}
+
+// correlated is implemented by events that carry a correlation id.
+type correlated interface {
+	CorrelationID() string
+}
+
+func messageHeaders(service string, data any) []kafka.Header {
+	headers := []kafka.Header{
+		{Key: HeaderContentType, Value: []byte(contentTypeJSON)},
+	}
+	if service != "" {
+		headers = append(headers, kafka.Header{Key: HeaderProducer, Value: []byte(service)})
+	}
+	if e, ok := data.(TypedEvent); ok {
+		headers = append(headers,
+			kafka.Header{Key: HeaderEventType, Value: []byte(e.EventType())},
+			kafka.Header{Key: HeaderSchemaVersion, Value: []byte(e.SchemaVersion())},
+			kafka.Header{Key: HeaderEventID, Value: []byte(e.EventID())},
+		)
+	}
+	if e, ok := data.(correlated); ok && e.CorrelationID() != "" {
+		headers = append(headers, kafka.Header{Key: HeaderCorrelationID, Value: []byte(e.CorrelationID())})
+	}
+	return headers
+}
+
+// HeaderValue returns the value of the first header named key, or an empty
+// string if the message does not have it.
+func HeaderValue(msg *kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -1,57 +1,320 @@
 package publisher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Settings holds the producer tuning knobs exposed by the services.
+type Settings struct {
+	// Acks is the number of acknowledgements required: "all", "1" or "0".
+	Acks string `yaml:"acks" toml:"acks"`
+	// Linger is how long to wait for more messages before sending a batch.
+	Linger time.Duration `yaml:"linger" toml:"linger"`
+	// BatchSize is the maximum number of messages batched in one request.
+	BatchSize int `yaml:"batchSize" toml:"batchSize"`
+}
+
+// Apply sets the settings on a kafka config map. Zero values are left to
+// librdkafka defaults.
+func (s Settings) Apply(config *kafka.ConfigMap) error {
+	if s.Acks != "" {
+		if err := config.SetKey("acks", s.Acks); err != nil {
+			return err
+		}
+	}
+	// Idempotence keeps retried batches from being reordered within a
+	// partition. It requires acknowledgement from all replicas.
+	if s.Acks == "" || s.Acks == "all" {
+		if err := config.SetKey("enable.idempotence", true); err != nil {
+			return err
+		}
+	}
+	if s.Linger > 0 {
+		if err := config.SetKey("linger.ms", int(s.Linger.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	if s.BatchSize > 0 {
+		if err := config.SetKey("batch.num.messages", s.BatchSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TopicNamer maps the topic given to the publish methods to its name on the
+// cluster, i.e to apply an environment prefix.
+type TopicNamer interface {
+	Name(topic string) string
+}
+
+func topicName(topics TopicNamer, topic string) string {
+	if topics == nil {
+		return topic
+	}
+	return topics.Name(topic)
+}
+
+// Validator checks an encoded event against the schema of its type.
+type Validator interface {
+	Validate(eventType string, data []byte) error
+}
+
+// validate checks msg with v. Messages without an event type have no schema
+// to check against.
+func validate(v Validator, msg *kafka.Message) error {
+	eventType := HeaderValue(msg, HeaderEventType)
+	if v == nil || eventType == "" {
+		return nil
+	}
+	return v.Validate(eventType, msg.Value)
+}
+
+// Encoder converts validated JSON events to the wire format of a codec.
+type Encoder interface {
+	ContentType() string
+	Supports(eventType string) bool
+	Encode(eventType string, data []byte) ([]byte, error)
+}
+
+// encode converts msg with e and records the content type. Events e has no
+// schema for, and messages without an event type, stay JSON.
+func encode(e Encoder, msg *kafka.Message) error {
+	eventType := HeaderValue(msg, HeaderEventType)
+	if e == nil || eventType == "" || !e.Supports(eventType) {
+		return nil
+	}
+	data, err := e.Encode(eventType, msg.Value)
+	if err != nil {
+		return err
+	}
+	msg.Value = data
+	setHeader(msg, HeaderContentType, e.ContentType())
+	return nil
+}
+
+// Producer is a long-lived Kafka producer shared by a service. Messages are
+// published asynchronously and delivery reports are handled in the background.
 type Producer struct {
 	Client *kafka.Producer
+	// Topics resolves topic names before publishing. Nil publishes to the
+	// topics as given.
+	Topics TopicNamer
+	// Schemas rejects events that don't match their schema. Nil publishes
+	// events unchecked.
+	Schemas Validator
+	// Codec encodes events after validating them. Nil publishes JSON.
+	Codec Encoder
+	// Envelope selects the legacy or a CloudEvents envelope for events.
+	// Empty is legacy.
+	Envelope Envelope
+	// service is reported in the producer header of every message.
+	service string
+	log     *slog.Logger
+	wg      sync.WaitGroup
 }
 
-func New(config *kafka.ConfigMap) (*Producer, error) {
+func New(config *kafka.ConfigMap, service string, log *slog.Logger) (*Producer, error) {
 	p, err := kafka.NewProducer(config)
 	if err != nil {
 		return nil, err
 	}
-	return &Producer{
-		Client: p,
-	}, nil
+	producer := &Producer{
+		Client:  p,
+		service: service,
+		log:     log,
+	}
+
+	producer.wg.Add(1)
+	go producer.handleEvents()
+
+	return producer, nil
+}
+
+// handleEvents drains the producer's events channel, reporting failed
+// deliveries of messages published with PublishEvent.
+func (p *Producer) handleEvents() {
+	defer p.wg.Done()
+	for e := range p.Client.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			endSpan(ev)
+			metrics.EventProduced(*ev.TopicPartition.Topic, ev.TopicPartition.Error)
+			if ev.TopicPartition.Error != nil {
+				p.log.Error("delivery failed",
+					"topic", *ev.TopicPartition.Topic,
+					"key", string(ev.Key),
+					"event_id", HeaderValue(ev, HeaderEventID),
+					"error", ev.TopicPartition.Error,
+				)
+			}
+		case kafka.Error:
+			p.log.Error("producer error", "code", ev.Code(), "error", ev)
+		}
+	}
 }
 
-func (p *Producer) PublishEvent(topic string, data any) error {
-	msg, err := json.Marshal(data)
+// PublishEvent enqueues data for asynchronous delivery to topic. Messages
+// with the same key are delivered to the same partition, preserving their
+// order. A nil error only means the message was queued; delivery failures are
+// logged.
+func (p *Producer) PublishEvent(ctx context.Context, topic, key string, data any) error {
+	msg, err := p.message(topic, key, data)
 	if err != nil {
 		return fmt.Errorf("publish event: %w", err)
 	}
 
-	err = p.Client.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic},
-		Value:          msg,
-	}, nil)
+	// The span ends when the delivery report arrives.
+	_, span := tracing.StartProducer(ctx, msg)
+	msg.Opaque = span
+	if err := p.Client.Produce(msg, nil); err != nil {
+		tracing.RecordError(span, err)
+		span.End()
+		return fmt.Errorf("publish event: %w", err)
+	}
+	return nil
+}
+
+// PublishEventSync publishes data to topic with the given key and waits for
+// its delivery report, returning the delivery error if any.
+func (p *Producer) PublishEventSync(ctx context.Context, topic, key string, data any) error {
+	msg, err := p.message(topic, key, data)
 	if err != nil {
 		return fmt.Errorf("publish event: %w", err)
 	}
 
-	p.Client.Flush(500)
+	_, span := tracing.StartProducer(ctx, msg)
+	defer span.End()
+
+	delivery := make(chan kafka.Event, 1)
+	if err := p.Client.Produce(msg, delivery); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("publish event: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		tracing.RecordError(span, ctx.Err())
+		return fmt.Errorf("publish event: %w", ctx.Err())
+	case e := <-delivery:
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("publish event: unexpected delivery event %v", e)
+		}
+		metrics.EventProduced(*msg.TopicPartition.Topic, m.TopicPartition.Error)
+		if m.TopicPartition.Error != nil {
+			tracing.RecordError(span, m.TopicPartition.Error)
+			return fmt.Errorf("publish event: %w", m.TopicPartition.Error)
+		}
+		return nil
+	}
+}
+
+// message returns the message of data for topic under its cluster name,
+// checked against its schema, encoded and wrapped in the envelope.
+func (p *Producer) message(topic, key string, data any) (*kafka.Message, error) {
+	msg, err := newMessage(p.service, topicName(p.Topics, topic), key, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(p.Schemas, msg); err != nil {
+		return nil, err
+	}
+	if err := encode(p.Codec, msg); err != nil {
+		return nil, err
+	}
+	if err := wrap(p.Envelope, p.service, msg, data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Forward enqueues a consumed message for asynchronous delivery to topic,
+// keeping its key, value and headers, i.e. to set aside a message that can't
+// be handled. It is neither validated nor encoded again.
+func (p *Producer) Forward(ctx context.Context, topic string, consumed *kafka.Message) error {
+	msg := forwardedMessage(topicName(p.Topics, topic), consumed)
+	_, span := tracing.StartProducer(ctx, msg)
+	msg.Opaque = span
+	if err := p.Client.Produce(msg, nil); err != nil {
+		tracing.RecordError(span, err)
+		span.End()
+		return fmt.Errorf("forward message: %w", err)
+	}
 	return nil
+}
 
+func forwardedMessage(topic string, consumed *kafka.Message) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            consumed.Key,
+		Value:          consumed.Value,
+		Headers:        slices.Clone(consumed.Headers),
+	}
 }
 
-func (p *Producer) Close() {
-	p.Client.Close()
+// endSpan ends the producer span attached to a delivered message.
+func endSpan(msg *kafka.Message) {
+	span, ok := msg.Opaque.(trace.Span)
+	if !ok {
+		return
+	}
+	tracing.RecordError(span, msg.TopicPartition.Error)
+	span.End()
 }
 
-func PublishEvent(hostAddress, topic string, data any) error {
-	p, err := New(&kafka.ConfigMap{
-		"bootstrap.servers": hostAddress,
-	})
+func newMessage(service, topic, key string, data any) (*kafka.Message, error) {
+	value, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("publis event: %w", err)
+		return nil, err
+	}
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          value,
+		Headers:        messageHeaders(service, data),
 	}
-	defer p.Close()
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	return msg, nil
+}
 
-	return p.PublishEvent(topic, data)
+// Flush waits up to timeout for outstanding messages to be delivered and
+// returns the number of messages still in flight.
+func (p *Producer) Flush(timeout time.Duration) int {
+	return p.Client.Flush(int(timeout.Milliseconds()))
+}
+
+// Shutdown waits up to timeout for outstanding messages to be delivered,
+// then purges whatever is left and closes the producer. Purged messages are
+// logged as failed deliveries. It returns the number of undelivered messages.
+func (p *Producer) Shutdown(timeout time.Duration) int {
+	n := p.Flush(timeout)
+	if n > 0 {
+		p.log.Error("closing producer with undelivered messages", "count", n)
+		if err := p.Client.Purge(kafka.PurgeQueue | kafka.PurgeInFlight); err != nil {
+			p.log.Error("purging producer", "error", err)
+		}
+		// Serve the delivery reports of the purged messages.
+		p.Flush(time.Second)
+	}
+	p.Client.Close()
+	p.wg.Wait()
+	return n
+}
+
+// Close flushes outstanding messages and closes the producer.
+func (p *Producer) Close() {
+	p.Shutdown(5 * time.Second)
 }
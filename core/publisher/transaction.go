@@ -0,0 +1,210 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+	"github.com/snirkop89/ppe-ecommerce/core/tracing"
+)
+
+// Retries of a transaction commit failing with a retriable error. The
+// backoff doubles after each attempt.
+const (
+	commitAttempts = 5
+	commitBackoff  = 100 * time.Millisecond
+)
+
+// Event is a single message to be published as part of a transaction.
+type Event struct {
+	Topic string
+	// Key selects the partition, see Producer.PublishEvent.
+	Key  string
+	Data any
+	// Forward, when set, is a consumed message published as is instead of
+	// Data, see Producer.Forward.
+	Forward *kafka.Message
+}
+
+// TransactionalProducer publishes the events produced while handling a
+// consumed message in one Kafka transaction, together with the consumer
+// offset of that message. Either all events and the offset commit become
+// visible, or none of them do.
+type TransactionalProducer struct {
+	Client *kafka.Producer
+	// Topics resolves the topics of published events, see Producer.Topics.
+	Topics TopicNamer
+	// Schemas validates published events, see Producer.Schemas.
+	Schemas Validator
+	// Codec encodes published events, see Producer.Codec.
+	Codec Encoder
+	// Envelope wraps published events, see Producer.Envelope.
+	Envelope Envelope
+	service  string
+}
+
+// NewTransactional creates a producer with the given transactional id and
+// initializes its transactions. The id must be stable across restarts of the
+// same service instance and unique between instances, or they fence each
+// other.
+func NewTransactional(ctx context.Context, config *kafka.ConfigMap, transactionalID, service string) (*TransactionalProducer, error) {
+	if err := config.SetKey("transactional.id", transactionalID); err != nil {
+		return nil, err
+	}
+	// Delivery failures surface through CommitTransaction.
+	if err := config.SetKey("go.delivery.reports", false); err != nil {
+		return nil, err
+	}
+
+	p, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.InitTransactions(ctx); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("init transactions: %w", err)
+	}
+	return &TransactionalProducer{
+		Client:  p,
+		service: service,
+	}, nil
+}
+
+// PublishWithOffset produces events and commits the offset following consumed
+// for the consumer's group in a single transaction. On failure the
+// transaction is aborted and the caller is expected to rewind the consumer
+// so that consumed is processed again. Without events, only the offset is
+// committed, for messages handled without publishing anything.
+func (p *TransactionalProducer) PublishWithOffset(ctx context.Context, consumer *kafka.Consumer, consumed *kafka.Message, events ...Event) error {
+	if err := p.Client.BeginTransaction(); err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	resolved := make([]Event, len(events))
+	for i, e := range events {
+		e.Topic = topicName(p.Topics, e.Topic)
+		resolved[i] = e
+	}
+	events = resolved
+
+	for _, e := range events {
+		msg, err := p.message(e)
+		if err != nil {
+			return p.abort(ctx, events, fmt.Errorf("publish event: %w", err))
+		}
+		_, span := tracing.StartProducer(ctx, msg)
+		err = p.Client.Produce(msg, nil)
+		tracing.RecordError(span, err)
+		span.End()
+		if err != nil {
+			return p.abort(ctx, events, fmt.Errorf("publish event: %w", err))
+		}
+	}
+
+	meta, err := consumer.GetConsumerGroupMetadata()
+	if err != nil {
+		return p.abort(ctx, events, fmt.Errorf("consumer group metadata: %w", err))
+	}
+	offset := consumed.TopicPartition
+	offset.Offset++
+	err = p.Client.SendOffsetsToTransaction(ctx, []kafka.TopicPartition{offset}, meta)
+	if err != nil {
+		return p.abort(ctx, events, fmt.Errorf("send offsets: %w", err))
+	}
+
+	// Retriable commit errors are retried a few times, backing off, before
+	// the transaction is given up.
+	backoff := commitBackoff
+	for attempt := 1; ; attempt++ {
+		err = p.Client.CommitTransaction(ctx)
+		if err == nil {
+			for _, e := range events {
+				metrics.EventProduced(e.Topic, nil)
+			}
+			return nil
+		}
+		var kerr kafka.Error
+		if errors.As(err, &kerr) && kerr.IsRetriable() && attempt < commitAttempts {
+			select {
+			case <-ctx.Done():
+				return p.abort(ctx, events, fmt.Errorf("commit transaction: %w", errors.Join(err, ctx.Err())))
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			continue
+		}
+		if errors.As(err, &kerr) && (kerr.TxnRequiresAbort() || kerr.IsRetriable()) {
+			return p.abort(ctx, events, fmt.Errorf("commit transaction: %w", err))
+		}
+		for _, e := range events {
+			metrics.EventProduced(e.Topic, err)
+		}
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+}
+
+// message returns the message of e, checked and encoded like
+// Producer.PublishEvent does unless it is forwarded.
+func (p *TransactionalProducer) message(e Event) (*kafka.Message, error) {
+	if e.Forward != nil {
+		return forwardedMessage(e.Topic, e.Forward), nil
+	}
+	msg, err := newMessage(p.service, e.Topic, e.Key, e.Data)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(p.Schemas, msg); err != nil {
+		return nil, err
+	}
+	if err := encode(p.Codec, msg); err != nil {
+		return nil, err
+	}
+	if err := wrap(p.Envelope, p.service, msg, e.Data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (p *TransactionalProducer) abort(ctx context.Context, events []Event, cause error) error {
+	for _, e := range events {
+		metrics.EventProduced(e.Topic, cause)
+	}
+	// The transaction is aborted even when ctx is done, within a bound.
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), commitBackoff*(1<<commitAttempts))
+		defer cancel()
+	}
+	if err := p.Client.AbortTransaction(ctx); err != nil {
+		return errors.Join(cause, fmt.Errorf("abort transaction: %w", err))
+	}
+	return cause
+}
+
+// IsFatal reports whether err left the transactional producer unusable, as
+// when a newer instance with the same transactional id fenced it. The
+// consumer must stop rather than handle further messages.
+func IsFatal(err error) bool {
+	var kerr kafka.Error
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	switch kerr.Code() {
+	case kafka.ErrFenced, kafka.ErrProducerFenced:
+		return true
+	}
+	return kerr.IsFatal()
+}
+
+// Rewind moves the consumer back to consumed so it is delivered again after
+// an aborted transaction.
+func Rewind(consumer *kafka.Consumer, consumed *kafka.Message) error {
+	return consumer.Seek(consumed.TopicPartition, 0)
+}
+
+func (p *TransactionalProducer) Close() {
+	p.Client.Close()
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snirkop89/ppe-ecommerce/core/auth"
+	"github.com/snirkop89/ppe-ecommerce/core/httpio"
+	"github.com/snirkop89/ppe-ecommerce/core/metrics"
+)
+
+// KeyFunc returns the key a request is limited by, or false when the limit
+// doesn't apply to it.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ByAPIKey keys the requests of API key clients by the name of their key.
+// It must run after auth.Middleware.
+func ByAPIKey(r *http.Request) (string, bool) {
+	p, ok := auth.FromContext(r.Context())
+	if !ok || p.Method != auth.MethodAPIKey {
+		return "", false
+	}
+	return p.Subject, true
+}
+
+// ByIP keys requests by the IP of the client. It runs before
+// auth.Middleware, so clients guessing credentials are throttled too. The IP
+// is read from the connection, behind a proxy chi's middleware.RealIP must
+// run first.
+func ByIP(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, true
+	}
+	return host, true
+}
+
+// Middleware limits the requests of each key by l. The state of the key's
+// bucket is sent in the RateLimit-* headers of the IETF draft
+// "RateLimit header fields for HTTP", and requests over the limit are
+// rejected with 429 and a Retry-After header.
+func Middleware(l *Limiter, key KeyFunc) func(http.Handler) http.Handler {
+	// The policy is the bucket size and the time to refill it.
+	policy := fmt.Sprintf("%d;w=%d", l.Burst, seconds(l.refill(float64(l.Burst))))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := key(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res := l.Allow(k)
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+			if !res.Allowed {
+				metrics.RateLimited(l.Name)
+				httpio.TooManyRequestsResponse(w, r, res.RetryAfter, "too many requests, retry later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds d up to whole seconds, as the headers require.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/snirkop89/ppe-ecommerce/core/auth"
+)
+
+func TestMiddleware(t *testing.T) {
+	c := newClock()
+	l := NewLimiter("test", 30, 2)
+	l.Now = c.Now
+	handler := Middleware(l, ByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	type want struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}
+	tests := []struct {
+		name  string
+		after time.Duration
+		addr  string
+		want  want
+	}{
+		{"first", 0, "192.0.2.1:5000", want{http.StatusNoContent, "1", "2", ""}},
+		{"second", 0, "192.0.2.1:5001", want{http.StatusNoContent, "0", "4", ""}},
+		{"over the limit", 0, "192.0.2.1:5002", want{http.StatusTooManyRequests, "0", "4", "2"}},
+		// Retry-After is rounded up to whole seconds.
+		{"still over the limit", 500 * time.Millisecond, "192.0.2.1:5003", want{http.StatusTooManyRequests, "0", "4", "2"}},
+		{"another IP", 0, "192.0.2.2:5000", want{http.StatusNoContent, "1", "2", ""}},
+		{"refilled", 1500 * time.Millisecond, "192.0.2.1:5004", want{http.StatusNoContent, "0", "4", ""}},
+	}
+	for _, tt := range tests {
+		c.Advance(tt.after)
+		r := httptest.NewRequest("POST", "/v1/orders", nil)
+		r.RemoteAddr = tt.addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		h := rec.Header()
+		got := want{rec.Code, h.Get("RateLimit-Remaining"), h.Get("RateLimit-Reset"), h.Get("Retry-After")}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		if policy := h.Get("RateLimit-Policy"); policy != "2;w=4" {
+			t.Errorf("%s: got policy %q, want 2;w=4", tt.name, policy)
+		}
+	}
+}
+
+func TestByAPIKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		key       string
+		ok        bool
+	}{
+		{"unauthenticated", nil, "", false},
+		{"api key", &auth.Principal{Subject: "acme", Method: auth.MethodAPIKey}, "acme", true},
+		{"token", &auth.Principal{Subject: "user-42", Method: auth.MethodJWT}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/orders", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.NewContext(r.Context(), tt.principal))
+			}
+			key, ok := ByAPIKey(r)
+			if key != tt.key || ok != tt.ok {
+				t.Errorf("got %q, %t, want %q, %t", key, ok, tt.key, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Package ratelimit throttles clients of the HTTP APIs.
+//
+// Limiter is a set of token buckets, one per key such as a client IP or an
+// API key, applied to requests by Middleware. Window counts events per key
+// over a sliding time window, for limits checked inside handlers, such as
+// orders per customer.
+//
+// Limits are kept in memory: every instance of a service enforces them on
+// the requests it serves.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter allows bursts of up to Burst requests per key, refilled at Rate
+// requests per second.
+type Limiter struct {
+	// Name identifies the limit in metrics.
+	Name  string
+	Rate  float64
+	Burst int
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Result is the state of a key's bucket after a request.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket.
+	Limit int
+	// Remaining is the requests allowed right away.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, when this
+	// one wasn't.
+	RetryAfter time.Duration
+}
+
+// NewLimiter returns a limiter of perMinute requests per key a minute,
+// with bursts of burst requests.
+func NewLimiter(name string, perMinute, burst int) *Limiter {
+	return &Limiter{Name: name, Rate: float64(perMinute) / 60, Burst: burst}
+}
+
+// Allow takes a token from the bucket of key, when it has one.
+func (l *Limiter) Allow(key string) Result {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	res := Result{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.refill(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = l.refill(float64(l.Burst) - b.tokens)
+	return res
+}
+
+// refill returns the time to refill tokens.
+func (l *Limiter) refill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.Rate * float64(time.Second))
+}
+
+// sweep forgets the buckets that are full again, at most once per time it
+// takes to fill a bucket, so idle clients don't hold memory.
+func (l *Limiter) sweep(now time.Time) {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+		l.lastSweep = now
+	}
+	full := l.refill(float64(l.Burst))
+	if now.Sub(l.lastSweep) < full {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Window allows up to Limit events per key within any period of Period.
+type Window struct {
+	// Name identifies the limit in metrics.
+	Name   string
+	Limit  int
+	Period time.Duration
+	// Now returns the current time, time.Now when nil.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewWindow returns a window of limit events per key within period.
+func NewWindow(name string, limit int, period time.Duration) *Window {
+	return &Window{Name: name, Limit: limit, Period: period}
+}
+
+// Allow records an event of key, unless key already had Limit events in
+// the last Period. Otherwise it returns the time until the oldest of them
+// leaves the window.
+func (w *Window) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	if w.Now != nil {
+		now = w.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.events == nil {
+		w.events = make(map[string][]time.Time)
+		w.lastSweep = now
+	}
+	if now.Sub(w.lastSweep) >= w.Period {
+		for k, events := range w.events {
+			if now.Sub(events[len(events)-1]) >= w.Period {
+				delete(w.events, k)
+			}
+		}
+		w.lastSweep = now
+	}
+
+	events := w.events[key]
+	for len(events) > 0 && now.Sub(events[0]) >= w.Period {
+		events = events[1:]
+	}
+	if len(events) >= w.Limit {
+		w.events[key] = events
+		return false, w.Period - now.Sub(events[0])
+	}
+	w.events[key] = append(events, now)
+	return true, 0
+}
+
+// Refund takes back the latest event of key recorded by Allow, for an event
+// that didn't happen after all, i.e an order that was rejected.
+func (w *Window) Refund(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.events[key]
+	switch len(events) {
+	case 0:
+	case 1:
+		delete(w.events, key)
+	default:
+		w.events[key] = events[:len(events)-1]
+	}
+}
+
+// Config sets the limits of an API. A zero rate disables its limit.
+type Config struct {
+	// IPPerMinute limits the requests of each client IP, authenticated or
+	// not, in bursts of up to IPBurst.
+	IPPerMinute int `yaml:"ipPerMinute" toml:"ipPerMinute"`
+	IPBurst     int `yaml:"ipBurst" toml:"ipBurst"`
+	// APIKeyPerMinute limits the requests of each API key, in bursts of up
+	// to APIKeyBurst.
+	APIKeyPerMinute int `yaml:"apiKeyPerMinute" toml:"apiKeyPerMinute"`
+	APIKeyBurst     int `yaml:"apiKeyBurst" toml:"apiKeyBurst"`
+	// CustomerOrders limits the orders of each customer email within
+	// CustomerWindow.
+	CustomerOrders int           `yaml:"customerOrders" toml:"customerOrders"`
+	CustomerWindow time.Duration `yaml:"customerWindow" toml:"customerWindow"`
+	// TrustProxy reads client IPs from the X-Forwarded-For and X-Real-IP
+	// headers, set by a reverse proxy in front of the service.
+	TrustProxy bool `yaml:"trustProxy" toml:"trustProxy"`
+}
+
+// Validate reports every invalid limit.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(ok bool, msg string) {
+		if !ok {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+	check(cfg.IPPerMinute >= 0, "rate-limit-ip must not be negative")
+	check(cfg.IPPerMinute == 0 || cfg.IPBurst > 0, "rate-limit-ip-burst must be positive")
+	check(cfg.APIKeyPerMinute >= 0, "rate-limit-api-key must not be negative")
+	check(cfg.APIKeyPerMinute == 0 || cfg.APIKeyBurst > 0, "rate-limit-api-key-burst must be positive")
+	check(cfg.CustomerOrders >= 0, "rate-limit-customer-orders must not be negative")
+	check(cfg.CustomerOrders == 0 || cfg.CustomerWindow > 0, "rate-limit-customer-window must be positive")
+	return errors.Join(errs...)
+}
@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a fake time source advanced by the tests.
+type clock struct{ now time.Time }
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestLimiter(t *testing.T) {
+	type step struct {
+		// after is the time since the previous request.
+		after time.Duration
+		key   string
+		want  Result
+	}
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		steps     []step
+	}{
+		{
+			name:      "burst then rejected",
+			perMinute: 60,
+			burst:     3,
+			steps: []step{
+				{key: "a", want: Result{Allowed: true, Limit: 3, Remaining: 2, Reset: time.Second}},
+				{key: "a", want: Result{Allowed: true, Limit: 3, Remaining: 1, Reset: 2 * time.Second}},
+				{key: "a", want: Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 3 * time.Second}},
+				{key: "a", want: Result{Limit: 3, Remaining: 0, Reset: 3 * time.Second, RetryAfter: time.Second}},
+			},
+		},
+		{
+			name:      "refill",
+			perMinute: 60,
+			burst:     2,
+			steps: []step{
+				{key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+				{key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+				{after: 500 * time.Millisecond, key: "a", want: Result{Limit: 2, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+				{after: 500 * time.Millisecond, key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}},
+				// The bucket never holds more than the burst.
+				{after: time.Hour, key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}},
+			},
+		},
+		{
+			name:      "keys have their own buckets",
+			perMinute: 1,
+			burst:     1,
+			steps: []step{
+				{key: "a", want: Result{Allowed: true, Limit: 1, Remaining: 0, Reset: time.Minute}},
+				{key: "b", want: Result{Allowed: true, Limit: 1, Remaining: 0, Reset: time.Minute}},
+				{after: 30 * time.Second, key: "a", want: Result{Limit: 1, Remaining: 0, Reset: 30 * time.Second, RetryAfter: 30 * time.Second}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			l := NewLimiter("test", tt.perMinute, tt.burst)
+			l.Now = c.Now
+			for i, s := range tt.steps {
+				c.Advance(s.after)
+				if got := l.Allow(s.key); got != s.want {
+					t.Fatalf("request %d: got %+v, want %+v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	c := newClock()
+	l := NewLimiter("test", 60, 2)
+	l.Now = c.Now
+	l.Allow("a")
+	c.Advance(time.Second)
+	l.Allow("b")
+	// a is full again once the time to fill a bucket passed, b is not.
+	c.Advance(time.Second)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("bucket a, full again, was not swept")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("bucket b was swept")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	type step struct {
+		// after is the time since the previous event.
+		after  time.Duration
+		key    string
+		refund bool
+		// allowed and retryAfter are the result of Allow, unless refund.
+		allowed    bool
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit int
+		steps []step
+	}{
+		{
+			name:  "limit reached",
+			limit: 2,
+			steps: []step{
+				{key: "a", allowed: true},
+				{after: 10 * time.Minute, key: "a", allowed: true},
+				{after: 10 * time.Minute, key: "a", retryAfter: 40 * time.Minute},
+				{key: "b", allowed: true},
+			},
+		},
+		{
+			name:  "events expire",
+			limit: 2,
+			steps: []step{
+				{key: "a", allowed: true},
+				{after: 30 * time.Minute, key: "a", allowed: true},
+				{after: 29 * time.Minute, key: "a", retryAfter: time.Minute},
+				// The first event leaves the window, the second is still in.
+				{after: time.Minute, key: "a", allowed: true},
+				{key: "a", retryAfter: 30 * time.Minute},
+			},
+		},
+		{
+			name:  "rejected events are not recorded",
+			limit: 1,
+			steps: []step{
+				{key: "a", allowed: true},
+				{after: 30 * time.Minute, key: "a", retryAfter: 30 * time.Minute},
+				{after: 30 * time.Minute, key: "a", allowed: true},
+			},
+		},
+		{
+			name:  "refund",
+			limit: 2,
+			steps: []step{
+				{key: "a", allowed: true},
+				{after: time.Minute, key: "a", allowed: true},
+				{key: "a", refund: true},
+				{key: "a", allowed: true},
+				{key: "a", retryAfter: 59 * time.Minute},
+				// Refunding a key without events does nothing.
+				{key: "b", refund: true},
+				{key: "b", allowed: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClock()
+			w := NewWindow("test", tt.limit, time.Hour)
+			w.Now = c.Now
+			for i, s := range tt.steps {
+				c.Advance(s.after)
+				if s.refund {
+					w.Refund(s.key)
+					continue
+				}
+				allowed, retryAfter := w.Allow(s.key)
+				if allowed != s.allowed || retryAfter != s.retryAfter {
+					t.Fatalf("event %d: got %t, retry after %s, want %t, retry after %s", i, allowed, retryAfter, s.allowed, s.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{"disabled", Config{}, true},
+		{"enabled", Config{IPPerMinute: 60, IPBurst: 10, APIKeyPerMinute: 600, APIKeyBurst: 100, CustomerOrders: 5, CustomerWindow: time.Hour}, true},
+		{"negative rate", Config{IPPerMinute: -1}, false},
+		{"no burst", Config{APIKeyPerMinute: 600}, false},
+		{"no window", Config{CustomerOrders: 5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package topic creates the Kafka topics a service depends on and checks
+// that existing topics match their declared configuration.
+package topic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// requestTimeout bounds each admin request made by the provisioner.
+const requestTimeout = 30 * time.Second
+
+// Spec declares a topic required by a service.
+type Spec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	// Retention is how long messages are kept, zero keeps them forever.
+	Retention time.Duration
+	// Compact keeps only the latest message of each key.
+	Compact bool
+}
+
+func (s Spec) config() map[string]string {
+	retention := "-1"
+	if s.Retention > 0 {
+		retention = strconv.FormatInt(s.Retention.Milliseconds(), 10)
+	}
+	config := map[string]string{"retention.ms": retention}
+	if s.Compact {
+		config["cleanup.policy"] = "compact"
+		if s.Retention > 0 {
+			config["cleanup.policy"] = "compact,delete"
+		}
+	}
+	return config
+}
+
+var errNotProvisioned = errors.New("topics not provisioned yet")
+
+// Provisioner creates missing topics and validates existing ones against
+// their specs. The outcome of the last run is reported by Check.
+type Provisioner struct {
+	admin *kafka.AdminClient
+	log   *slog.Logger
+	specs []Spec
+
+	mu  sync.Mutex
+	err error
+}
+
+func NewProvisioner(admin *kafka.AdminClient, log *slog.Logger, specs ...Spec) *Provisioner {
+	return &Provisioner{
+		admin: admin,
+		log:   log,
+		specs: specs,
+		err:   errNotProvisioned,
+	}
+}
+
+// Ensure creates the topics that don't exist yet and compares the partition
+// count, replication factor and retention of the others with their specs.
+// Existing topics are never altered; a mismatch is returned as an error for
+// an operator to resolve.
+func (p *Provisioner) Ensure(ctx context.Context) error {
+	err := p.ensure(ctx)
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	return err
+}
+
+func (p *Provisioner) ensure(ctx context.Context) error {
+	md, err := p.admin.GetMetadata(nil, true, int(requestTimeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("topic metadata: %w", err)
+	}
+
+	var missing, existing []Spec
+	for _, s := range p.specs {
+		if _, ok := md.Topics[s.Name]; ok {
+			existing = append(existing, s)
+			continue
+		}
+		missing = append(missing, s)
+	}
+
+	var errs []error
+	if len(missing) > 0 {
+		errs = append(errs, p.create(ctx, missing))
+	}
+	for _, s := range existing {
+		errs = append(errs, validateLayout(s, md.Topics[s.Name]))
+	}
+	if len(existing) > 0 {
+		errs = append(errs, p.validateConfig(ctx, existing))
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provisioner) create(ctx context.Context, specs []Spec) error {
+	req := make([]kafka.TopicSpecification, 0, len(specs))
+	for _, s := range specs {
+		req = append(req, kafka.TopicSpecification{
+			Topic:             s.Name,
+			NumPartitions:     s.Partitions,
+			ReplicationFactor: s.ReplicationFactor,
+			Config:            s.config(),
+		})
+	}
+	res, err := p.admin.CreateTopics(ctx, req, kafka.SetAdminOperationTimeout(requestTimeout))
+	if err != nil {
+		return fmt.Errorf("create topics: %w", err)
+	}
+
+	var errs []error
+	for _, r := range res {
+		switch r.Error.Code() {
+		case kafka.ErrNoError:
+			p.log.Info("Created topic", "topic", r.Topic)
+		case kafka.ErrTopicAlreadyExists:
+			// Another instance created it first.
+		default:
+			errs = append(errs, fmt.Errorf("create topic %s: %w", r.Topic, r.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validateLayout(s Spec, md kafka.TopicMetadata) error {
+	if md.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %s: %w", s.Name, md.Error)
+	}
+	var errs []error
+	if n := len(md.Partitions); n != s.Partitions {
+		errs = append(errs, fmt.Errorf("topic %s has %d partitions, want %d", s.Name, n, s.Partitions))
+	}
+	for _, part := range md.Partitions {
+		if n := len(part.Replicas); n != s.ReplicationFactor {
+			errs = append(errs, fmt.Errorf("topic %s has replication factor %d, want %d", s.Name, n, s.ReplicationFactor))
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Provisioner) validateConfig(ctx context.Context, specs []Spec) error {
+	resources := make([]kafka.ConfigResource, 0, len(specs))
+	for _, s := range specs {
+		resources = append(resources, kafka.ConfigResource{Type: kafka.ResourceTopic, Name: s.Name})
+	}
+	res, err := p.admin.DescribeConfigs(ctx, resources, kafka.SetAdminRequestTimeout(requestTimeout))
+	if err != nil {
+		return fmt.Errorf("describe topic configs: %w", err)
+	}
+
+	byName := make(map[string]kafka.ConfigResourceResult, len(res))
+	for _, r := range res {
+		byName[r.Name] = r
+	}
+	var errs []error
+	for _, s := range specs {
+		r := byName[s.Name]
+		if r.Error.Code() != kafka.ErrNoError {
+			errs = append(errs, fmt.Errorf("describe topic %s: %w", s.Name, r.Error))
+			continue
+		}
+		for key, want := range s.config() {
+			if got := r.Config[key].Value; got != want {
+				errs = append(errs, fmt.Errorf("topic %s has %s=%s, want %s", s.Name, key, got, want))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run repeats Ensure every interval until ctx is done, so readiness recovers
+// once an operator fixes a mismatch or the cluster becomes reachable.
+func (p *Provisioner) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			prev := p.Check(ctx)
+			err := p.Ensure(ctx)
+			if err != nil && (prev == nil || prev.Error() != err.Error()) {
+				p.log.Error("Topics don't match their specs", "error", err)
+			}
+		}
+	}
+}
+
+// Check reports the outcome of the last Ensure. It is meant to be registered
+// as a readiness check.
+func (p *Provisioner) Check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HeadersCarrier adapts Kafka message headers to a propagation.TextMapCarrier
+// so the trace context travels with the message.
+type HeadersCarrier struct {
+	msg *kafka.Message
+}
+
+func NewHeadersCarrier(msg *kafka.Message) HeadersCarrier {
+	return HeadersCarrier{msg: msg}
+}
+
+func (c HeadersCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c HeadersCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// StartProducer starts a producer span for msg and injects its context into
+// the message headers.
+func StartProducer(ctx context.Context, msg *kafka.Message) (context.Context, trace.Span) {
+	topic := topicName(msg)
+	ctx, span := Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(msg)...),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, NewHeadersCarrier(msg))
+	return ctx, span
+}
+
+// StartConsumer starts a consumer span for msg that continues the trace
+// propagated in its headers.
+func StartConsumer(ctx context.Context, msg *kafka.Message) (context.Context, trace.Span) {
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), NewHeadersCarrier(msg))
+	topic := topicName(msg)
+	attrs := append(messagingAttributes(msg),
+		semconv.MessagingKafkaDestinationPartition(int(msg.TopicPartition.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(msg.TopicPartition.Offset)),
+	)
+	// The span is linked to the trace of the message while keeping the
+	// values stored in ctx, such as logging attributes and cancellation.
+	ctx = trace.ContextWithRemoteSpanContext(ctx, trace.SpanContextFromContext(parent))
+	return Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func messagingAttributes(msg *kafka.Message) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationName(topicName(msg)),
+	}
+	if len(msg.Key) > 0 {
+		attrs = append(attrs, semconv.MessagingKafkaMessageKey(string(msg.Key)))
+	}
+	return attrs
+}
+
+func topicName(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
+}
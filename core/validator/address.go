@@ -0,0 +1,194 @@
+package validator
+
+import (
+	"regexp"
+	"strings"
+)
+
+// countries holds the ISO 3166-1 alpha-2 country codes.
+var countries = func() map[string]bool {
+	codes := strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI
+		BJ BL BM BN BO BQ BR BS BT BV BW BY BZ CA CC CD CF CG CH CI CK CL CM CN
+		CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK
+		FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM
+		HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN
+		KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK
+		ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP
+		NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW
+		SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF
+		TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI
+		VN VU WF WS YE YT ZA ZM ZW`)
+	m := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		m[c] = true
+	}
+	return m
+}()
+
+// postalCodes holds the postal code format of the countries we ship to,
+// matched against normalized codes, see NormalizePostalCode.
+var postalCodes = map[string]*regexp.Regexp{
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"CA": regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z] \d[ABCEGHJ-NPRSTV-Z]\d$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`),
+	"IL": regexp.MustCompile(`^\d{7}$`),
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"BG": regexp.MustCompile(`^\d{4}$`),
+	"HR": regexp.MustCompile(`^\d{5}$`),
+	"CY": regexp.MustCompile(`^\d{4}$`),
+	"CZ": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"EE": regexp.MustCompile(`^\d{5}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"GR": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"HU": regexp.MustCompile(`^\d{4}$`),
+	"IE": regexp.MustCompile(`^[AC-FHKNPRTV-Y]\d[\dW] ?[0-9AC-FHKNPRTV-Y]{4}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"LV": regexp.MustCompile(`^(LV-)?\d{4}$`),
+	"LT": regexp.MustCompile(`^(LT-)?\d{5}$`),
+	"LU": regexp.MustCompile(`^(L-)?\d{4}$`),
+	"MT": regexp.MustCompile(`^[A-Z]{3} ?\d{2,4}$`),
+	"NL": regexp.MustCompile(`^\d{4} [A-Z]{2}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"RO": regexp.MustCompile(`^\d{6}$`),
+	"SK": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"SI": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+}
+
+// states holds the subdivisions of the countries whose addresses require
+// one, by code and by name for normalization.
+var states = map[string]map[string]string{
+	"US": {
+		"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas",
+		"CA": "California", "CO": "Colorado", "CT": "Connecticut", "DE": "Delaware",
+		"DC": "District of Columbia", "FL": "Florida", "GA": "Georgia", "HI": "Hawaii",
+		"ID": "Idaho", "IL": "Illinois", "IN": "Indiana", "IA": "Iowa",
+		"KS": "Kansas", "KY": "Kentucky", "LA": "Louisiana", "ME": "Maine",
+		"MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota",
+		"MS": "Mississippi", "MO": "Missouri", "MT": "Montana", "NE": "Nebraska",
+		"NV": "Nevada", "NH": "New Hampshire", "NJ": "New Jersey", "NM": "New Mexico",
+		"NY": "New York", "NC": "North Carolina", "ND": "North Dakota", "OH": "Ohio",
+		"OK": "Oklahoma", "OR": "Oregon", "PA": "Pennsylvania", "RI": "Rhode Island",
+		"SC": "South Carolina", "SD": "South Dakota", "TN": "Tennessee", "TX": "Texas",
+		"UT": "Utah", "VT": "Vermont", "VA": "Virginia", "WA": "Washington",
+		"WV": "West Virginia", "WI": "Wisconsin", "WY": "Wyoming",
+		"AS": "American Samoa", "GU": "Guam", "MP": "Northern Mariana Islands",
+		"PR": "Puerto Rico", "VI": "U.S. Virgin Islands",
+		"AA": "Armed Forces Americas", "AE": "Armed Forces Europe", "AP": "Armed Forces Pacific",
+	},
+	"CA": {
+		"AB": "Alberta", "BC": "British Columbia", "MB": "Manitoba", "NB": "New Brunswick",
+		"NL": "Newfoundland and Labrador", "NS": "Nova Scotia", "NT": "Northwest Territories",
+		"NU": "Nunavut", "ON": "Ontario", "PE": "Prince Edward Island", "QC": "Quebec",
+		"SK": "Saskatchewan", "YT": "Yukon",
+	},
+}
+
+var phoneRX = regexp.MustCompile(`^\+[1-9]\d{6,14}$`)
+
+// Country checks that value is an ISO 3166-1 alpha-2 country code.
+func (v *Validator) Country(field, value string) bool {
+	if !v.Required(field, value) {
+		return false
+	}
+	return v.Check(countries[value], field, CodeInvalidCountry, "must be an ISO 3166-1 alpha-2 country code")
+}
+
+// PostalCode checks that value is a postal code of country. Countries
+// without a known format only require a value.
+func (v *Validator) PostalCode(field, country, value string) bool {
+	if !v.Required(field, value) {
+		return false
+	}
+	rx, ok := postalCodes[country]
+	if !ok {
+		return true
+	}
+	return v.Check(rx.MatchString(value), field, CodeInvalidPostalCode, "must be a valid postal code of "+country)
+}
+
+// State checks that value is a subdivision code of country, for the
+// countries whose addresses require one. Elsewhere it is optional.
+func (v *Validator) State(field, country, value string) bool {
+	codes, ok := states[country]
+	if !ok {
+		return true
+	}
+	if !v.Required(field, value) {
+		return false
+	}
+	_, ok = codes[value]
+	return v.Check(ok, field, CodeInvalidState, "must be a subdivision code of "+country)
+}
+
+// Phone checks that value is an international phone number in E.164
+// format, see NormalizePhone.
+func (v *Validator) Phone(field, value string) bool {
+	return v.Check(phoneRX.MatchString(value), field, CodeInvalidPhone, "must be an international phone number, i.e +15551234567")
+}
+
+// NormalizeCountry trims and upper-cases a country code.
+func NormalizeCountry(country string) string {
+	return strings.ToUpper(strings.TrimSpace(country))
+}
+
+// NormalizePostalCode upper-cases a postal code of country and puts the
+// separating space where the country's format has one.
+func NormalizePostalCode(country, code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), " "))
+	switch country {
+	case "CA", "GB", "NL":
+		// The inward part is the last 3 characters, 2 in the Netherlands.
+		compact := strings.ReplaceAll(code, " ", "")
+		n := 3
+		if country == "NL" {
+			n = 2
+		}
+		if len(compact) > n {
+			return compact[:len(compact)-n] + " " + compact[len(compact)-n:]
+		}
+	}
+	return code
+}
+
+// NormalizeState returns the code of a subdivision of country given by code
+// or name. States of other countries are only trimmed.
+func NormalizeState(country, state string) string {
+	state = strings.Join(strings.Fields(state), " ")
+	codes, ok := states[country]
+	if !ok {
+		return state
+	}
+	if _, ok := codes[strings.ToUpper(state)]; ok {
+		return strings.ToUpper(state)
+	}
+	for code, name := range codes {
+		if strings.EqualFold(name, state) {
+			return code
+		}
+	}
+	return state
+}
+
+// NormalizePhone removes the punctuation commonly used to format phone
+// numbers, and a leading 00 international prefix.
+func NormalizePhone(phone string) string {
+	phone = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, phone)
+	if strings.HasPrefix(phone, "00") {
+		phone = "+" + phone[2:]
+	}
+	return phone
+}
@@ -0,0 +1,176 @@
+package validator
+
+import "testing"
+
+func TestCountry(t *testing.T) {
+	tests := []struct {
+		country string
+		code    string
+	}{
+		{"US", ""},
+		{"DE", ""},
+		{"", CodeRequired},
+		{"us", CodeInvalidCountry},
+		{"USA", CodeInvalidCountry},
+		{"XX", CodeInvalidCountry},
+	}
+	for _, tt := range tests {
+		v := New()
+		v.Country("country", tt.country)
+		if got := firstCode(v); got != tt.code {
+			t.Errorf("%q: got code %q, want %q", tt.country, got, tt.code)
+		}
+	}
+}
+
+func TestPostalCode(t *testing.T) {
+	tests := []struct {
+		country string
+		code    string
+		valid   bool
+	}{
+		{"US", "07001", true},
+		{"US", "07001-1234", true},
+		{"US", "7001", false},
+		{"US", "07001-12", false},
+		{"CA", "K1A 0B1", true},
+		{"CA", "K1A0B1", false},
+		{"CA", "D1A 0B1", false},
+		{"GB", "SW1A 1AA", true},
+		{"GB", "M1 1AE", true},
+		{"GB", "SW1A1AA", false},
+		{"IL", "6100000", true},
+		{"IL", "61000", false},
+		{"DE", "10115", true},
+		{"DE", "1011", false},
+		{"FR", "75008", true},
+		{"NL", "1012 AB", true},
+		{"NL", "1012AB", false},
+		{"PL", "00-950", true},
+		{"PL", "00950", false},
+		{"PT", "1000-001", true},
+		{"IE", "D02 X285", true},
+		{"IE", "D02X285", true},
+		{"IE", "B02 X285", false},
+		{"SE", "113 51", true},
+		{"SE", "11351", true},
+		{"LV", "LV-1050", true},
+		{"LV", "1050", true},
+		{"MT", "VLT 1117", true},
+		// Countries without a known format take any value.
+		{"JP", "100-0001", true},
+		{"JP", "anything", true},
+	}
+	for _, tt := range tests {
+		v := New()
+		if got := v.PostalCode("postalCode", tt.country, tt.code); got != tt.valid {
+			t.Errorf("%s %q: got valid %t, want %t", tt.country, tt.code, got, tt.valid)
+		}
+		if !tt.valid && firstCode(v) != CodeInvalidPostalCode {
+			t.Errorf("%s %q: got code %q, want %s", tt.country, tt.code, firstCode(v), CodeInvalidPostalCode)
+		}
+	}
+
+	// A postal code is required everywhere.
+	for _, country := range []string{"US", "JP"} {
+		v := New()
+		v.PostalCode("postalCode", country, "")
+		if got := firstCode(v); got != CodeRequired {
+			t.Errorf("%s without postal code: got code %q, want %s", country, got, CodeRequired)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	tests := []struct {
+		country string
+		state   string
+		code    string
+	}{
+		{"US", "NJ", ""},
+		{"US", "PR", ""},
+		{"US", "", CodeRequired},
+		{"US", "ON", CodeInvalidState},
+		{"US", "New Jersey", CodeInvalidState},
+		{"CA", "ON", ""},
+		{"CA", "NJ", CodeInvalidState},
+		{"CA", "", CodeRequired},
+		// Elsewhere the state is optional and free form.
+		{"DE", "", ""},
+		{"DE", "Bayern", ""},
+		{"GB", "Greater London", ""},
+	}
+	for _, tt := range tests {
+		v := New()
+		v.State("state", tt.country, tt.state)
+		if got := firstCode(v); got != tt.code {
+			t.Errorf("%s %q: got code %q, want %q", tt.country, tt.state, got, tt.code)
+		}
+	}
+}
+
+func TestPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		valid bool
+	}{
+		{"+15551234567", true},
+		{"+972501234567", true},
+		{"+4930123", true},
+		{"15551234567", false},
+		{"+05551234567", false},
+		{"+1555", false},
+		{"+1555123456789012", false},
+		{"+1 555 123 4567", false},
+	}
+	for _, tt := range tests {
+		v := New()
+		if got := v.Phone("phone", tt.phone); got != tt.valid {
+			t.Errorf("%q: got valid %t, want %t", tt.phone, got, tt.valid)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"country", NormalizeCountry(" gb "), "GB"},
+		{"missing country", NormalizeCountry("  "), ""},
+
+		{"US postal code", NormalizePostalCode("US", " 07001 "), "07001"},
+		{"CA postal code", NormalizePostalCode("CA", "k1a0b1"), "K1A 0B1"},
+		{"CA postal code with spaces", NormalizePostalCode("CA", " k1a  0b1"), "K1A 0B1"},
+		{"GB postal code", NormalizePostalCode("GB", "sw1a1aa"), "SW1A 1AA"},
+		{"short GB postal code", NormalizePostalCode("GB", "m11ae"), "M1 1AE"},
+		{"NL postal code", NormalizePostalCode("NL", "1012ab"), "1012 AB"},
+		{"too short to split", NormalizePostalCode("GB", "1a"), "1A"},
+		{"other postal code", NormalizePostalCode("SE", "113  51"), "113 51"},
+
+		{"state code", NormalizeState("US", " nj "), "NJ"},
+		{"state name", NormalizeState("US", "new  jersey"), "NJ"},
+		{"province name", NormalizeState("CA", "Ontario"), "ON"},
+		{"unknown state", NormalizeState("US", "Gotham"), "Gotham"},
+		{"state elsewhere", NormalizeState("DE", "  Bayern "), "Bayern"},
+
+		{"formatted phone", NormalizePhone("+1 (555) 123-4567"), "+15551234567"},
+		{"dotted phone", NormalizePhone("+1.555.123.4567"), "+15551234567"},
+		{"00 prefix", NormalizePhone("00972 50 123 4567"), "+972501234567"},
+		{"no phone", NormalizePhone(""), ""},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// firstCode returns the code of the first error of v, empty when valid.
+func firstCode(v *Validator) string {
+	if errs := v.Errors(); len(errs) > 0 {
+		return errs[0].Code
+	}
+	return ""
+}
@@ -1,32 +1,138 @@
 package validator
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
 
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// Error codes, stable identifiers of the failed rule for clients.
+const (
+	CodeRequired          = "required"
+	CodeTooShort          = "too_short"
+	CodeTooLong           = "too_long"
+	CodeOutOfRange        = "out_of_range"
+	CodeInvalidUUID       = "invalid_uuid"
+	CodeInvalidEmail      = "invalid_email"
+	CodeInvalidPostalCode = "invalid_postal_code"
+	CodeInvalidCountry    = "invalid_country"
+	CodeInvalidState      = "invalid_state"
+	CodeInvalidPhone      = "invalid_phone"
+	CodeNotAllowed        = "not_allowed"
+	CodeLimitExceeded     = "limit_exceeded"
+	CodeInvalid           = "invalid"
+)
+
+// FieldError is a rule a field failed.
+type FieldError struct {
+	// Field is the path of the field, i.e products[2].quantity.
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Validator collects the errors of a value and its nested fields. Nested
+// and Element return validators for parts of the value that report to the
+// same list, prefixing their fields with the path to the part.
 type Validator struct {
-	Errors map[string]string
+	path   string
+	errors *[]FieldError
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{errors: new([]FieldError)}
 }
 
-func (v *Validator) Valid() bool {
-	return len(v.Errors) == 0
+// Nested returns a validator for the object in field name.
+func (v *Validator) Nested(name string) *Validator {
+	return &Validator{path: v.field(name), errors: v.errors}
+}
+
+// Element returns a validator for element i of the array in field name.
+func (v *Validator) Element(name string, i int) *Validator {
+	return &Validator{path: fmt.Sprintf("%s[%d]", v.field(name), i), errors: v.errors}
 }
 
-func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+func (v *Validator) field(name string) string {
+	if v.path == "" {
+		return name
+	}
+	if name == "" {
+		return v.path
 	}
+	return v.path + "." + name
+}
+
+// Valid reports whether no rule failed, in any part of the value.
+func (v *Validator) Valid() bool {
+	return len(*v.errors) == 0
+}
 
+// Errors returns every failed rule in the order they were checked.
+func (v *Validator) Errors() []FieldError {
+	return *v.errors
 }
 
-func (v *Validator) Check(ok bool, field, message string) {
+// AddError records that field failed the rule code. A field can fail
+// several rules.
+func (v *Validator) AddError(field, code, message string) {
+	*v.errors = append(*v.errors, FieldError{Field: v.field(field), Code: code, Message: message})
+}
+
+// Check adds an error unless ok. It returns ok, so dependent checks can be
+// skipped.
+func (v *Validator) Check(ok bool, field, code, message string) bool {
 	if !ok {
-		v.AddError(field, message)
+		v.AddError(field, code, message)
 	}
+	return ok
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) bool {
+	return v.Check(strings.TrimSpace(value) != "", field, CodeRequired, "is required")
+}
+
+// MinLength checks that value has at least n characters.
+func (v *Validator) MinLength(field, value string, n int) bool {
+	return v.Check(utf8.RuneCountInString(value) >= n, field, CodeTooShort, fmt.Sprintf("must be at least %d characters", n))
+}
+
+// MaxLength checks that value has at most n characters.
+func (v *Validator) MaxLength(field, value string, n int) bool {
+	return v.Check(utf8.RuneCountInString(value) <= n, field, CodeTooLong, fmt.Sprintf("must be at most %d characters", n))
+}
+
+// Min checks that value is at least min.
+func (v *Validator) Min(field string, value, min int) bool {
+	return v.Check(value >= min, field, CodeOutOfRange, fmt.Sprintf("must be at least %d", min))
+}
+
+// Range checks that value is between min and max, inclusive.
+func (v *Validator) Range(field string, value, min, max int) bool {
+	return v.Check(value >= min && value <= max, field, CodeOutOfRange, fmt.Sprintf("must be between %d and %d", min, max))
+}
+
+// UUID checks that value is a UUID.
+func (v *Validator) UUID(field, value string) bool {
+	_, err := uuid.Parse(value)
+	return v.Check(err == nil, field, CodeInvalidUUID, "must be a UUID")
+}
+
+// Email checks that value is an email address.
+func (v *Validator) Email(field, value string) bool {
+	return v.Check(Matches(value, EmailRX), field, CodeInvalidEmail, "must be a valid email address")
+}
+
+// OneOf checks that value is one of allowed.
+func (v *Validator) OneOf(field, value string, allowed ...string) bool {
+	return v.Check(slices.Contains(allowed, value), field, CodeNotAllowed, "must be one of "+strings.Join(allowed, ", "))
 }
 
 func Matches(value string, rx *regexp.Regexp) bool {
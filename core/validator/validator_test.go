@@ -0,0 +1,83 @@
+package validator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaths(t *testing.T) {
+	v := New()
+	v.AddError("orderId", CodeRequired, "is required")
+	product := v.Element("products", 2)
+	product.AddError("quantity", CodeOutOfRange, "must be at least 1")
+	address := v.Nested("customer").Nested("shippingAddress")
+	address.AddError("postalCode", CodeInvalidPostalCode, "must be a valid postal code of US")
+	// An empty field reports the part itself.
+	v.Element("products", 0).AddError("", CodeInvalid, "is invalid")
+	v.Nested("customer").Element("phones", 1).Nested("number").AddError("", CodeInvalidPhone, "is invalid")
+
+	want := []string{
+		"orderId",
+		"products[2].quantity",
+		"customer.shippingAddress.postalCode",
+		"products[0]",
+		"customer.phones[1].number",
+	}
+	var got []string
+	for _, e := range v.Errors() {
+		got = append(got, e.Field)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields %v, want %v", got, want)
+	}
+	// Parts report to the validator they came from.
+	if product.Valid() || v.Valid() {
+		t.Error("got valid with errors in parts")
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(v *Validator) bool
+		// code is the code of the failed rule, none when it passes.
+		code string
+	}{
+		{"required", func(v *Validator) bool { return v.Required("f", "a") }, ""},
+		{"required empty", func(v *Validator) bool { return v.Required("f", "") }, CodeRequired},
+		{"required blank", func(v *Validator) bool { return v.Required("f", " \t") }, CodeRequired},
+		{"min length", func(v *Validator) bool { return v.MinLength("f", "ab", 2) }, ""},
+		{"min length counts characters", func(v *Validator) bool { return v.MinLength("f", "Zoë", 3) }, ""},
+		{"too short", func(v *Validator) bool { return v.MinLength("f", "a", 2) }, CodeTooShort},
+		{"max length", func(v *Validator) bool { return v.MaxLength("f", "éé", 2) }, ""},
+		{"too long", func(v *Validator) bool { return v.MaxLength("f", "abc", 2) }, CodeTooLong},
+		{"min", func(v *Validator) bool { return v.Min("f", 1, 1) }, ""},
+		{"below min", func(v *Validator) bool { return v.Min("f", 0, 1) }, CodeOutOfRange},
+		{"range", func(v *Validator) bool { return v.Range("f", 365, 1, 365) }, ""},
+		{"out of range", func(v *Validator) bool { return v.Range("f", 366, 1, 365) }, CodeOutOfRange},
+		{"uuid", func(v *Validator) bool { return v.UUID("f", "6bc91dc9-b1f1-48c8-9dea-e600470dfb95") }, ""},
+		{"invalid uuid", func(v *Validator) bool { return v.UUID("f", "42") }, CodeInvalidUUID},
+		{"email", func(v *Validator) bool { return v.Email("f", "bruce@wayne.com") }, ""},
+		{"invalid email", func(v *Validator) bool { return v.Email("f", "bruce@") }, CodeInvalidEmail},
+		{"one of", func(v *Validator) bool { return v.OneOf("f", "b", "a", "b") }, ""},
+		{"not one of", func(v *Validator) bool { return v.OneOf("f", "c", "a", "b") }, CodeNotAllowed},
+		{"check", func(v *Validator) bool { return v.Check(true, "f", CodeInvalid, "is invalid") }, ""},
+		{"failed check", func(v *Validator) bool { return v.Check(false, "f", CodeLimitExceeded, "is over") }, CodeLimitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			ok := tt.check(v)
+			if ok != (tt.code == "") || v.Valid() != ok {
+				t.Fatalf("got %t, valid %t, errors %v", ok, v.Valid(), v.Errors())
+			}
+			if ok {
+				return
+			}
+			errs := v.Errors()
+			if len(errs) != 1 || errs[0].Field != "f" || errs[0].Code != tt.code || errs[0].Message == "" {
+				t.Errorf("got errors %+v, want 1 error on f with code %s", errs, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package schemas
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/codec"
+)
+
+//go:embed registry
+var registryFiles embed.FS
+
+// Encoded lists the event types published in the configured codec. The
+// others, such as dead letters carrying whatever failed, are always JSON.
+var Encoded = []string{
+	v1.OrderReceivedType,
+	v1.OrderConfirmedType,
+	v1.OrderPickedAndPackedType,
+	v1.NotificationType,
+}
+
+// Codecs returns every codec for the events in api/v1, with the Avro and
+// Protobuf schemas read from the file registry in dir. An empty dir uses
+// the registry embedded in this package.
+func Codecs(dir string) (*codec.Set, error) {
+	var fsys fs.FS = os.DirFS(dir)
+	if dir == "" {
+		var err error
+		if fsys, err = fs.Sub(registryFiles, "registry"); err != nil {
+			return nil, err
+		}
+	}
+	registry, err := codec.LoadFileRegistry(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	avro, err := codec.NewAvro(registry, Encoded...)
+	if err != nil {
+		return nil, fmt.Errorf("load codecs: %w", err)
+	}
+	protobuf, err := codec.NewProtobuf(registry, Encoded...)
+	if err != nil {
+		return nil, fmt.Errorf("load codecs: %w", err)
+	}
+	return codec.NewSet(avro, protobuf), nil
+}
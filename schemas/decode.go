@@ -0,0 +1,101 @@
+package schemas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/codec"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+)
+
+// Policy decides how a consumer treats events that don't exactly match the
+// Go type it decodes them into.
+type Policy string
+
+const (
+	// Strict rejects unknown fields and events newer than the consumer.
+	Strict Policy = "strict"
+	// Tolerant ignores unknown fields, so producers can add fields before
+	// every consumer is upgraded. The known fields must still be valid.
+	Tolerant Policy = "tolerant"
+)
+
+// ParsePolicy parses the name of a decoding policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Strict, Tolerant:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown decode policy %q, want strict or tolerant", s)
+}
+
+// Decoder decodes consumed events into their current Go type, migrating
+// older versions and validating the result against its schema.
+type Decoder struct {
+	Registry  *Registry
+	Upcasters *v1.Upcasters
+	Policy    Policy
+	// Codecs convert messages to JSON by their content type. Nil only
+	// reads JSON.
+	Codecs *codec.Set
+}
+
+// DecodeMessage decodes the eventType event in msg into v, reading its wire
+// format and schema version from the message headers. See Decode.
+func (d *Decoder) DecodeMessage(eventType string, msg *kafka.Message, v any) error {
+	codecs := d.Codecs
+	if codecs == nil {
+		codecs = codec.NewSet()
+	}
+	c, err := codecs.ByContentType(publisher.HeaderValue(msg, publisher.HeaderContentType))
+	if err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	data, err := c.Decode(eventType, msg.Value)
+	if err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	return d.Decode(eventType, publisher.HeaderValue(msg, publisher.HeaderSchemaVersion), data, v)
+}
+
+// Decode upcasts data, an eventType event at the given schema version, and
+// decodes it into v. Failures that retrying can't fix, such as invalid or
+// unsupported events, are returned as a *ValidationError.
+func (d *Decoder) Decode(eventType, version string, data []byte, v any) error {
+	n, err := v1.ParseVersion(version)
+	if err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	if n > d.Upcasters.Current(eventType) && d.Policy == Strict {
+		return &ValidationError{EventType: eventType, Err: fmt.Errorf("schema version %d is newer than %d", n, d.Upcasters.Current(eventType))}
+	}
+	data, err = d.Upcasters.Upcast(eventType, n, data)
+	if err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+
+	if d.Policy == Strict {
+		if err := d.Registry.Validate(eventType, data); err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return &ValidationError{EventType: eventType, Err: err}
+		}
+		return nil
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	// Validate what the consumer understood, without the unknown fields.
+	known, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Registry.Validate(eventType, known)
+}
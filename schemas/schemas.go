@@ -0,0 +1,95 @@
+// Package schemas holds the JSON Schema documents of the events in api/v1
+// and validates encoded events against them. The registry directory holds
+// the Avro and Protobuf schemas of the same events, for the codecs in
+// core/codec.
+package schemas
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+)
+
+//go:embed *.json
+var files embed.FS
+
+// baseURL identifies the embedded documents, so references between them
+// resolve without touching the file system.
+const baseURL = "https://github.com/snirkop89/ppe-ecommerce/schemas/"
+
+// Files maps each event type to its schema document.
+var Files = map[string]string{
+	v1.OrderReceivedType:        "order_received.json",
+	v1.OrderConfirmedType:       "order_confirmed.json",
+	v1.OrderPickedAndPackedType: "order_picked_and_packed.json",
+	v1.OrderErrorType:           "error.json",
+	v1.NotificationType:         "notification.json",
+}
+
+// ValidationError reports an event that doesn't match its schema.
+type ValidationError struct {
+	EventType string
+	Err       error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("event %s violates its schema: %v", e.EventType, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Registry validates events against the compiled schemas.
+type Registry struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// Load compiles the schema of every event type.
+func Load() (*Registry, error) {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	err := fs.WalkDir(files, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := files.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return c.AddResource(baseURL+path, bytes.NewReader(data))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load schemas: %w", err)
+	}
+
+	r := &Registry{schemas: make(map[string]*jsonschema.Schema, len(Files))}
+	for eventType, file := range Files {
+		s, err := c.Compile(baseURL + file)
+		if err != nil {
+			return nil, fmt.Errorf("compile schema %s: %w", file, err)
+		}
+		r.schemas[eventType] = s
+	}
+	return r, nil
+}
+
+// Validate checks the JSON encoded event data against the schema of
+// eventType. Violations are returned as a *ValidationError.
+func (r *Registry) Validate(eventType string, data []byte) error {
+	s, ok := r.schemas[eventType]
+	if !ok {
+		return &ValidationError{EventType: eventType, Err: fmt.Errorf("no schema for event type %q", eventType)}
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	if err := s.Validate(doc); err != nil {
+		return &ValidationError{EventType: eventType, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package schemas
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	v1 "github.com/snirkop89/ppe-ecommerce/api/v1"
+	"github.com/snirkop89/ppe-ecommerce/core/codec"
+	"github.com/snirkop89/ppe-ecommerce/core/publisher"
+)
+
+func testOrder() v1.Order {
+	o := v1.Order{
+		OrderID: "3087d70d-b490-44cb-9567-65e3fb6652b5",
+		Products: []v1.Product{
+			{ProductID: "6bc91dc9-b1f1-48c8-9dea-e600470dfb95", Quantity: 1},
+		},
+		Customer: v1.Customer{
+			FirstName: "Bruce",
+			LastName:  "Wayne",
+			Email:     "bruce@wayne.com",
+		},
+	}
+	o.Customer.ShippingAddress = v1.Address{
+		Street:     "1 There St.",
+		City:       "Gotham",
+		State:      "NJ",
+		PostalCode: "07001",
+		Country:    "US",
+	}
+	return o
+}
+
+func testHeader() v1.Header {
+	h := v1.NewCausedHeader(v1.NewHeader())
+	// JSON keeps neither the monotonic clock nor the location.
+	h.PublishedAt = h.PublishedAt.Round(0).UTC()
+	return h
+}
+
+type typedEvent interface {
+	EventType() string
+}
+
+func TestRoundTrip(t *testing.T) {
+	events := []typedEvent{
+		v1.OrderReceived{Header: testHeader(), Order: testOrder(), PlacedBy: &v1.Principal{Subject: "bruce", Method: "jwt"}},
+		v1.OrderReceived{Header: testHeader(), Order: testOrder()},
+		v1.OrderConfirmed{Header: testHeader(), Order: testOrder()},
+		v1.OrderPickedAndPacked{Header: testHeader(), Order: testOrder()},
+		v1.OrderError{Header: testHeader(), Event: map[string]any{"orderId": "not validated"}},
+		v1.Notification{
+			Header:    testHeader(),
+			Type:      "email",
+			Recipient: "bruce@wayne.com",
+			From:      "orders@ppe4all",
+			Subject:   "Hi Bruce Wayne, your order has been confirmed",
+			Body:      "<p>We have received your order and it is being fullfilled!",
+		},
+	}
+
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	covered := make(map[string]bool)
+	for _, e := range events {
+		t.Run(e.EventType(), func(t *testing.T) {
+			covered[e.EventType()] = true
+			data, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Validate(e.EventType(), data); err != nil {
+				t.Fatalf("valid event rejected: %v", err)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(e))
+			if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if got := decoded.Elem().Interface(); !reflect.DeepEqual(got, e) {
+				t.Errorf("round trip changed the event\ngot:  %+v\nwant: %+v", got, e)
+			}
+		})
+	}
+
+	for eventType := range Files {
+		if !covered[eventType] {
+			t.Errorf("no round trip test for event type %s", eventType)
+		}
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := v1.OrderConfirmed{Header: testHeader(), Order: testOrder()}
+	tests := []struct {
+		name   string
+		mutate func(doc map[string]any)
+	}{
+		{"missing header", func(doc map[string]any) { delete(doc, "header") }},
+		{"unknown field", func(doc map[string]any) { doc["status"] = "confirmed" }},
+		{"no products", func(doc map[string]any) { doc["products"] = []any{} }},
+		{"invalid order id", func(doc map[string]any) { doc["orderId"] = "42" }},
+		{"invalid email", func(doc map[string]any) {
+			doc["customer"].(map[string]any)["emailAddress"] = "bruce"
+		}},
+		{"invalid country", func(doc map[string]any) {
+			doc["customer"].(map[string]any)["shippingAddress"].(map[string]any)["country"] = "usa"
+		}},
+		{"published at", func(doc map[string]any) {
+			doc["header"].(map[string]any)["publishedAt"] = time.Now().Format(time.Kitchen)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(valid)
+			var doc map[string]any
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			tt.mutate(doc)
+			data, _ = json.Marshal(doc)
+
+			err := r.Validate(v1.OrderConfirmedType, data)
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("got %v, want a *ValidationError", err)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := v1.OrderConfirmed{Header: testHeader(), Order: testOrder()}
+	current, _ := json.Marshal(want)
+
+	var doc map[string]any
+	if err := json.Unmarshal(current, &doc); err != nil {
+		t.Fatal(err)
+	}
+	doc["warehouse"] = "north"
+	extended, _ := json.Marshal(doc)
+
+	// Version 1 of the event in this test called the order ID "id".
+	doc["id"] = doc["orderId"]
+	delete(doc, "orderId")
+	delete(doc, "warehouse")
+	old, _ := json.Marshal(doc)
+	upcasters := v1.NewUpcasters(2)
+	upcasters.Register(v1.OrderConfirmedType, 1, func(doc map[string]any) error {
+		doc["orderId"] = doc["id"]
+		delete(doc, "id")
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		version string
+		data    []byte
+		wantErr bool
+	}{
+		{"strict current", Strict, "2", current, false},
+		{"strict unknown field", Strict, "2", extended, true},
+		{"tolerant unknown field", Tolerant, "2", extended, false},
+		{"strict upcast", Strict, "1", old, false},
+		{"upcast without version", Tolerant, "", old, false},
+		{"strict newer version", Strict, "3", current, true},
+		{"tolerant newer version", Tolerant, "3", extended, false},
+		{"invalid version", Tolerant, "v2", current, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decoder{Registry: r, Upcasters: upcasters, Policy: tt.policy}
+			var got v1.OrderConfirmed
+			err := d.Decode(v1.OrderConfirmedType, tt.version, tt.data, &got)
+			if tt.wantErr {
+				var invalid *ValidationError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("got %v, want a *ValidationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codecs, err := Codecs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{Registry: r, Upcasters: v1.DefaultUpcasters, Policy: Strict, Codecs: codecs}
+
+	events := []typedEvent{
+		v1.OrderReceived{Header: testHeader(), Order: testOrder(), PlacedBy: &v1.Principal{Subject: "acme-procurement", Method: "api_key"}},
+		v1.OrderReceived{Header: testHeader(), Order: testOrder()},
+		v1.OrderConfirmed{Header: testHeader(), Order: testOrder()},
+		v1.OrderPickedAndPacked{Header: testHeader(), Order: testOrder()},
+		v1.Notification{
+			Header:    testHeader(),
+			Type:      "email",
+			Recipient: "bruce@wayne.com",
+			From:      "orders@ppe4all",
+			Body:      "<p>We have received your order and it is being fullfilled!",
+		},
+	}
+	for _, format := range []string{codec.JSONFormat, codec.AvroFormat, codec.ProtobufFormat} {
+		c, err := codecs.ByFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			t.Run(format+"/"+e.EventType(), func(t *testing.T) {
+				data, _ := json.Marshal(e)
+				encoded, err := c.Encode(e.EventType(), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				msg := &kafka.Message{
+					Value:   encoded,
+					Headers: []kafka.Header{{Key: publisher.HeaderContentType, Value: []byte(c.ContentType())}},
+				}
+
+				decoded := reflect.New(reflect.TypeOf(e))
+				if err := d.DecodeMessage(e.EventType(), msg, decoded.Interface()); err != nil {
+					t.Fatal(err)
+				}
+				if got := decoded.Elem().Interface(); !reflect.DeepEqual(got, e) {
+					t.Errorf("round trip changed the event\ngot:  %+v\nwant: %+v", got, e)
+				}
+			})
+		}
+	}
+}
+
+func TestUpcastShippingCountry(t *testing.T) {
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := v1.OrderReceived{Header: testHeader(), Order: testOrder()}
+	data, _ := json.Marshal(want)
+
+	// Addresses of version 1 had no country, orders were only shipped
+	// within the US.
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	delete(doc["customer"].(map[string]any)["shippingAddress"].(map[string]any), "country")
+	old, _ := json.Marshal(doc)
+
+	d := &Decoder{Registry: r, Upcasters: v1.DefaultUpcasters, Policy: Strict}
+	var got v1.OrderReceived
+	if err := d.Decode(v1.OrderReceivedType, "1", old, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestEventVersions(t *testing.T) {
+	r, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{Registry: r, Upcasters: v1.DefaultUpcasters, Policy: Strict}
+	received, _ := json.Marshal(v1.OrderReceived{Header: testHeader(), Order: testOrder()})
+	confirmed, _ := json.Marshal(v1.OrderConfirmed{Header: testHeader(), Order: testOrder()})
+
+	tests := []struct {
+		name      string
+		eventType string
+		version   string
+		data      []byte
+		wantErr   bool
+	}{
+		{"received before placedBy", v1.OrderReceivedType, "2", received, false},
+		{"received without placedBy", v1.OrderReceivedType, v1.OrderReceivedVersion, received, false},
+		{"received newer version", v1.OrderReceivedType, "4", received, true},
+		{"confirmed", v1.OrderConfirmedType, v1.SchemaVersion, confirmed, false},
+		// Only OrderReceived moved to version 3.
+		{"confirmed newer version", v1.OrderConfirmedType, "3", confirmed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			err := d.Decode(tt.eventType, tt.version, tt.data, &got)
+			if got := err != nil; got != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}